@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/plugin"
 
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
@@ -19,11 +23,21 @@ var handshakeConfig = goPlugin.HandshakeConfig{
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		if err := runPlan(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logCtx := log.WithFields(log.Fields{"plugin": "trafficrouter"})
 	log.SetLevel(log.DebugLevel)
 
+	dryRun, _ := strconv.ParseBool(os.Getenv(plugin.DryRunEnvVar))
 	rpcPluginImp := &plugin.RpcPlugin{
 		LogCtx: logCtx,
+		DryRun: dryRun,
 	}
 
 	var pluginMap = map[string]goPlugin.Plugin{
@@ -6,16 +6,21 @@ import (
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/util"
 
 	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
 	"k8s.io/apimachinery/pkg/runtime"
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type networkV2Client struct {
-	routeTableClient *routeTableClient
+	routeTableClient         *routeTableClient
+	mirrorPolicyClient       *mirrorPolicyClient
+	virtualDestinationClient *virtualDestinationClient
 }
 
 type NetworkV2ClientSet interface {
 	RouteTables() RouteTableClient
+	MirrorPolicies() MirrorPolicyClient
+	VirtualDestinations() VirtualDestinationClient
 }
 
 type RouteTableClient interface {
@@ -34,12 +39,72 @@ type RouteTableReader interface {
 type RouteTableWriter interface {
 	// Patch patches the given RouteTable object.
 	PatchRouteTable(ctx context.Context, obj *networkv2.RouteTable, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error
+
+	// Apply applies the given RouteTable object server-side, as the FieldManager of the plugin, without taking over
+	// fields owned by other managers. The object is updated from the response.
+	ApplyRouteTable(ctx context.Context, obj *networkv2.RouteTable, opts ...k8sclient.PatchOption) error
 }
 
 type routeTableClient struct {
 	client k8sclient.Client
 }
 
+type MirrorPolicyClient interface {
+	MirrorPolicyReader
+	MirrorPolicyWriter
+}
+
+type MirrorPolicyReader interface {
+	// Get retrieves a MirrorPolicy for the given object key
+	GetMirrorPolicy(ctx context.Context, name string, namespace string) (*trafficv2.MirrorPolicy, error)
+
+	// List retrieves list of MirrorPolicies for a given namespace and list options.
+	ListMirrorPolicy(ctx context.Context, opts ...k8sclient.ListOption) ([]*trafficv2.MirrorPolicy, error)
+}
+
+type MirrorPolicyWriter interface {
+	// Create creates the given MirrorPolicy object.
+	CreateMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.CreateOption) error
+
+	// Patch patches the given MirrorPolicy object.
+	PatchMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error
+
+	// Delete deletes the given MirrorPolicy object.
+	DeleteMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.DeleteOption) error
+}
+
+type mirrorPolicyClient struct {
+	client k8sclient.Client
+}
+
+type VirtualDestinationClient interface {
+	VirtualDestinationReader
+	VirtualDestinationWriter
+}
+
+type VirtualDestinationReader interface {
+	// Get retrieves a VirtualDestination for the given object key
+	GetVirtualDestination(ctx context.Context, name string, namespace string) (*networkv2.VirtualDestination, error)
+
+	// List retrieves list of VirtualDestinations for a given namespace and list options.
+	ListVirtualDestination(ctx context.Context, opts ...k8sclient.ListOption) ([]*networkv2.VirtualDestination, error)
+}
+
+type VirtualDestinationWriter interface {
+	// Create creates the given VirtualDestination object.
+	CreateVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.CreateOption) error
+
+	// Patch patches the given VirtualDestination object.
+	PatchVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error
+
+	// Delete deletes the given VirtualDestination object.
+	DeleteVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.DeleteOption) error
+}
+
+type virtualDestinationClient struct {
+	client k8sclient.Client
+}
+
 func NewNetworkV2ClientSet() (NetworkV2ClientSet, error) {
 	cfg, err := util.GetKubeConfig()
 	if err != nil {
@@ -48,6 +113,7 @@ func NewNetworkV2ClientSet() (NetworkV2ClientSet, error) {
 
 	scheme := runtime.NewScheme()
 	networkv2.AddToScheme(scheme)
+	trafficv2.AddToScheme(scheme)
 	c, err := k8sclient.New(cfg, k8sclient.Options{
 		Scheme: scheme,
 	})
@@ -56,10 +122,20 @@ func NewNetworkV2ClientSet() (NetworkV2ClientSet, error) {
 	}
 
 	return networkV2Client{
-		routeTableClient: &routeTableClient{client: c},
+		routeTableClient:         &routeTableClient{client: c},
+		mirrorPolicyClient:       &mirrorPolicyClient{client: c},
+		virtualDestinationClient: &virtualDestinationClient{client: c},
 	}, nil
 }
 
 func (c networkV2Client) RouteTables() RouteTableClient {
 	return c.routeTableClient
 }
+
+func (c networkV2Client) MirrorPolicies() MirrorPolicyClient {
+	return c.mirrorPolicyClient
+}
+
+func (c networkV2Client) VirtualDestinations() VirtualDestinationClient {
+	return c.virtualDestinationClient
+}
@@ -0,0 +1,186 @@
+package gloo
+
+import (
+	"context"
+	"slices"
+
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type inMemoryClient struct {
+	routeTableClient         *inMemoryRouteTableClient
+	mirrorPolicyClient       *inMemoryMirrorPolicyClient
+	virtualDestinationClient *inMemoryVirtualDestinationClient
+}
+
+// NewInMemoryClientSet returns a client set holding the given RouteTables in memory, to run the plugin without a
+// cluster. Objects are found by their exact name and namespace like in a cluster, but the RouteTables are handed out
+// rather than copied, so that changes made to them are seen by later reads.
+func NewInMemoryClientSet(routeTables []*networkv2.RouteTable) NetworkV2ClientSet {
+	return inMemoryClient{
+		routeTableClient: &inMemoryRouteTableClient{&inMemoryObjects[*networkv2.RouteTable]{
+			resource: networkv2.SchemeGroupVersion.WithResource("routetables").GroupResource(),
+			objects:  routeTables,
+		}},
+		mirrorPolicyClient: &inMemoryMirrorPolicyClient{&inMemoryObjects[*trafficv2.MirrorPolicy]{
+			resource: trafficv2.SchemeGroupVersion.WithResource("mirrorpolicies").GroupResource(),
+		}},
+		virtualDestinationClient: &inMemoryVirtualDestinationClient{&inMemoryObjects[*networkv2.VirtualDestination]{
+			resource: networkv2.SchemeGroupVersion.WithResource("virtualdestinations").GroupResource(),
+		}},
+	}
+}
+
+func (c inMemoryClient) RouteTables() RouteTableClient {
+	return c.routeTableClient
+}
+
+func (c inMemoryClient) MirrorPolicies() MirrorPolicyClient {
+	return c.mirrorPolicyClient
+}
+
+func (c inMemoryClient) VirtualDestinations() VirtualDestinationClient {
+	return c.virtualDestinationClient
+}
+
+// inMemoryObjects holds objects of one kind by name and namespace
+type inMemoryObjects[T k8sclient.Object] struct {
+	resource schema.GroupResource
+	objects  []T
+}
+
+func (o *inMemoryObjects[T]) index(name, namespace string) int {
+	return slices.IndexFunc(o.objects, func(obj T) bool {
+		return obj.GetName() == name && obj.GetNamespace() == namespace
+	})
+}
+
+func (o *inMemoryObjects[T]) get(name, namespace string) (T, error) {
+	i := o.index(name, namespace)
+	if i < 0 {
+		var none T
+		return none, k8serrors.NewNotFound(o.resource, name)
+	}
+	return o.objects[i], nil
+}
+
+func (o *inMemoryObjects[T]) list(opts ...k8sclient.ListOption) []T {
+	listOpts := &k8sclient.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	var result []T
+	for _, obj := range o.objects {
+		if listOpts.Namespace != "" && obj.GetNamespace() != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		result = append(result, obj)
+	}
+	return result
+}
+
+func (o *inMemoryObjects[T]) create(obj T) error {
+	if o.index(obj.GetName(), obj.GetNamespace()) >= 0 {
+		return k8serrors.NewAlreadyExists(o.resource, obj.GetName())
+	}
+	o.objects = append(o.objects, obj)
+	return nil
+}
+
+// update replaces the stored object, unless it is the same one
+func (o *inMemoryObjects[T]) update(obj T, deepCopyInto func(in, out T)) error {
+	i := o.index(obj.GetName(), obj.GetNamespace())
+	if i < 0 {
+		return k8serrors.NewNotFound(o.resource, obj.GetName())
+	}
+	if any(o.objects[i]) != any(obj) {
+		deepCopyInto(obj, o.objects[i])
+	}
+	return nil
+}
+
+func (o *inMemoryObjects[T]) delete(obj T) error {
+	i := o.index(obj.GetName(), obj.GetNamespace())
+	if i < 0 {
+		return k8serrors.NewNotFound(o.resource, obj.GetName())
+	}
+	o.objects = slices.Delete(o.objects, i, i+1)
+	return nil
+}
+
+type inMemoryRouteTableClient struct {
+	routeTables *inMemoryObjects[*networkv2.RouteTable]
+}
+
+func (c *inMemoryRouteTableClient) GetRouteTable(ctx context.Context, name string, namespace string) (*networkv2.RouteTable, error) {
+	return c.routeTables.get(name, namespace)
+}
+
+func (c *inMemoryRouteTableClient) ListRouteTable(ctx context.Context, opts ...k8sclient.ListOption) ([]*networkv2.RouteTable, error) {
+	return c.routeTables.list(opts...), nil
+}
+
+// PatchRouteTable stores the already patched object; the patch itself isn't applied again
+func (c *inMemoryRouteTableClient) PatchRouteTable(ctx context.Context, obj *networkv2.RouteTable, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return c.routeTables.update(obj, (*networkv2.RouteTable).DeepCopyInto)
+}
+
+// ApplyRouteTable only checks that the RouteTable exists, as the applied object only has some of its fields
+func (c *inMemoryRouteTableClient) ApplyRouteTable(ctx context.Context, obj *networkv2.RouteTable, opts ...k8sclient.PatchOption) error {
+	_, err := c.routeTables.get(obj.Name, obj.Namespace)
+	return err
+}
+
+type inMemoryMirrorPolicyClient struct {
+	mirrorPolicies *inMemoryObjects[*trafficv2.MirrorPolicy]
+}
+
+func (c *inMemoryMirrorPolicyClient) GetMirrorPolicy(ctx context.Context, name string, namespace string) (*trafficv2.MirrorPolicy, error) {
+	return c.mirrorPolicies.get(name, namespace)
+}
+
+func (c *inMemoryMirrorPolicyClient) ListMirrorPolicy(ctx context.Context, opts ...k8sclient.ListOption) ([]*trafficv2.MirrorPolicy, error) {
+	return c.mirrorPolicies.list(opts...), nil
+}
+
+func (c *inMemoryMirrorPolicyClient) CreateMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.CreateOption) error {
+	return c.mirrorPolicies.create(obj)
+}
+
+func (c *inMemoryMirrorPolicyClient) PatchMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return c.mirrorPolicies.update(obj, (*trafficv2.MirrorPolicy).DeepCopyInto)
+}
+
+func (c *inMemoryMirrorPolicyClient) DeleteMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.DeleteOption) error {
+	return c.mirrorPolicies.delete(obj)
+}
+
+type inMemoryVirtualDestinationClient struct {
+	virtualDestinations *inMemoryObjects[*networkv2.VirtualDestination]
+}
+
+func (c *inMemoryVirtualDestinationClient) GetVirtualDestination(ctx context.Context, name string, namespace string) (*networkv2.VirtualDestination, error) {
+	return c.virtualDestinations.get(name, namespace)
+}
+
+func (c *inMemoryVirtualDestinationClient) ListVirtualDestination(ctx context.Context, opts ...k8sclient.ListOption) ([]*networkv2.VirtualDestination, error) {
+	return c.virtualDestinations.list(opts...), nil
+}
+
+func (c *inMemoryVirtualDestinationClient) CreateVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.CreateOption) error {
+	return c.virtualDestinations.create(obj)
+}
+
+func (c *inMemoryVirtualDestinationClient) PatchVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return c.virtualDestinations.update(obj, (*networkv2.VirtualDestination).DeepCopyInto)
+}
+
+func (c *inMemoryVirtualDestinationClient) DeleteVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.DeleteOption) error {
+	return c.virtualDestinations.delete(obj)
+}
@@ -0,0 +1,40 @@
+package gloo
+
+import (
+	"context"
+
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func (c *mirrorPolicyClient) GetMirrorPolicy(ctx context.Context, name string, namespace string) (*trafficv2.MirrorPolicy, error) {
+	mp := &trafficv2.MirrorPolicy{}
+	if err := c.client.Get(ctx, k8sclient.ObjectKey{Name: name, Namespace: namespace}, mp); err != nil {
+		return nil, err
+	}
+	return mp, nil
+}
+
+func (c *mirrorPolicyClient) ListMirrorPolicy(ctx context.Context, opts ...k8sclient.ListOption) ([]*trafficv2.MirrorPolicy, error) {
+	mpl := &trafficv2.MirrorPolicyList{}
+	if err := c.client.List(ctx, mpl, opts...); err != nil {
+		return nil, err
+	}
+	var result []*trafficv2.MirrorPolicy
+	for i := 0; i < len(mpl.Items); i++ {
+		result = append(result, &mpl.Items[i])
+	}
+	return result, nil
+}
+
+func (c *mirrorPolicyClient) CreateMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.CreateOption) error {
+	return c.client.Create(ctx, obj, opts...)
+}
+
+func (c *mirrorPolicyClient) PatchMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return c.client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *mirrorPolicyClient) DeleteMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.DeleteOption) error {
+	return c.client.Delete(ctx, obj, opts...)
+}
@@ -2,6 +2,11 @@ package gloo
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 
 	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
@@ -11,6 +16,7 @@ type patchConfig struct {
 	withAnnotations bool
 	withLabels      bool
 	withSpec        bool
+	resourceVersion string
 }
 
 type PatchOption func(p *patchConfig)
@@ -33,6 +39,14 @@ func WithSpec() PatchOption {
 	}
 }
 
+// WithResourceVersion makes the patch fail with a conflict unless the RouteTable still has the given resourceVersion
+func WithResourceVersion(resourceVersion string) PatchOption {
+	return func(p *patchConfig) {
+		p.resourceVersion = resourceVersion
+	}
+}
+
+// BuildRouteTablePatch returns a merge patch of the selected parts of the RouteTable, and whether it changes anything
 func BuildRouteTablePatch(current, desired *networkv2.RouteTable, opts ...PatchOption) ([]byte, bool, error) {
 	cfg := &patchConfig{}
 	for _, opt := range opts {
@@ -54,7 +68,26 @@ func BuildRouteTablePatch(current, desired *networkv2.RouteTable, opts ...PatchO
 		desired.Spec.DeepCopyInto(&des.Spec)
 	}
 
-	return createTwoWayMergePatch(cur, des, networkv2.RouteTable{})
+	patch, changed, err := createTwoWayMergePatch(cur, des, networkv2.RouteTable{})
+	if err != nil || !changed || cfg.resourceVersion == "" {
+		return patch, changed, err
+	}
+	return withResourceVersion(patch, cfg.resourceVersion)
+}
+
+func withResourceVersion(patch []byte, resourceVersion string) ([]byte, bool, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, false, err
+	}
+	metadata, _ := fields["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["resourceVersion"] = resourceVersion
+	fields["metadata"] = metadata
+	patch, err := json.Marshal(fields)
+	return patch, true, err
 }
 
 func createTwoWayMergePatch(orig, new, dataStruct interface{}) ([]byte, bool, error) {
@@ -72,3 +105,294 @@ func createTwoWayMergePatch(orig, new, dataStruct interface{}) ([]byte, bool, er
 	}
 	return patch, string(patch) != "{}", nil
 }
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type jsonPatchBuilder struct {
+	ops                   []jsonPatchOperation
+	err                   error
+	resourceVersionTested bool
+}
+
+func (b *jsonPatchBuilder) add(op, path string, value interface{}) {
+	operation := jsonPatchOperation{Op: op, Path: path}
+	if op != "remove" {
+		data, err := json.Marshal(value)
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+		operation.Value = data
+	}
+	b.ops = append(b.ops, operation)
+}
+
+// BuildRouteTableJSONPatch returns a JSON patch which changes the annotations, the destinations of the routes and the
+// routes themselves by index, and whether it changes anything. Every changed or removed element is tested first, so
+// the patch fails instead of changing the wrong element if the routes were reordered or changed meanwhile. Changes to
+// other parts of the spec can't be expressed and result in an error.
+// Annotations are tested the same way. As JSON patches can't test that an annotation is absent, adding one tests the
+// resourceVersion instead, so that two writers adding the same annotation don't overwrite each other.
+func BuildRouteTableJSONPatch(current, desired *networkv2.RouteTable) ([]byte, bool, error) {
+	cur, err := toJSONObject(current)
+	if err != nil {
+		return nil, false, err
+	}
+	des, err := toJSONObject(desired)
+	if err != nil {
+		return nil, false, err
+	}
+
+	b := &jsonPatchBuilder{}
+	curAnnotations, _ := jsonObjectAt(cur, "metadata")["annotations"].(map[string]interface{})
+	desAnnotations, _ := jsonObjectAt(des, "metadata")["annotations"].(map[string]interface{})
+	b.annotations(curAnnotations, desAnnotations, current.ResourceVersion)
+
+	curSpec := jsonObjectAt(cur, "spec")
+	desSpec := jsonObjectAt(des, "spec")
+	for _, field := range sortedKeys(curSpec, desSpec) {
+		switch field {
+		case "http", "tcp", "tls":
+			curRoutes, _ := curSpec[field].([]interface{})
+			desRoutes, _ := desSpec[field].([]interface{})
+			b.routes("/spec/"+field, curSpec[field] != nil, curRoutes, desRoutes)
+		default:
+			if !reflect.DeepEqual(curSpec[field], desSpec[field]) {
+				return nil, false, fmt.Errorf("spec field %s of RouteTable %s.%s changed, JSON patches only change routes", field, current.Namespace, current.Name)
+			}
+		}
+	}
+	if b.err != nil {
+		return nil, false, b.err
+	}
+	if len(b.ops) == 0 {
+		return nil, false, nil
+	}
+	patch, err := json.Marshal(b.ops)
+	return patch, err == nil, err
+}
+
+func (b *jsonPatchBuilder) annotations(cur, des map[string]interface{}, resourceVersion string) {
+	if len(cur) == 0 {
+		if len(des) > 0 {
+			b.testResourceVersion(resourceVersion)
+			b.add("add", "/metadata/annotations", des)
+		}
+		return
+	}
+	for _, key := range sortedKeys(cur, des) {
+		path := "/metadata/annotations/" + escapeJSONPointer(key)
+		previous, exists := cur[key]
+		value, desired := des[key]
+		switch {
+		case !desired:
+			b.add("test", path, previous)
+			b.add("remove", path, nil)
+		case !exists:
+			b.testResourceVersion(resourceVersion)
+			b.add("add", path, value)
+		case !reflect.DeepEqual(previous, value):
+			b.add("test", path, previous)
+			b.add("add", path, value)
+		}
+	}
+}
+
+// testResourceVersion tests the resourceVersion the patch is based on, once
+func (b *jsonPatchBuilder) testResourceVersion(resourceVersion string) {
+	if resourceVersion == "" || b.resourceVersionTested {
+		return
+	}
+	b.resourceVersionTested = true
+	b.add("test", "/metadata/resourceVersion", resourceVersion)
+}
+
+// routes aligns the current and desired routes, ignoring their destinations, and removes, adds and updates them by index
+func (b *jsonPatchBuilder) routes(path string, exists bool, cur, des []interface{}) {
+	if !exists {
+		if len(des) > 0 {
+			b.add("add", path, des)
+		}
+		return
+	}
+
+	index := 0
+	for _, step := range alignJSONLists(cur, des, withoutDestinations) {
+		elementPath := fmt.Sprintf("%s/%d", path, index)
+		switch {
+		case step.desired < 0:
+			b.add("test", elementPath, cur[step.current])
+			b.add("remove", elementPath, nil)
+		case step.current < 0:
+			b.add("add", elementPath, des[step.desired])
+			index++
+		default:
+			b.destinations(elementPath, cur[step.current].(map[string]interface{}), des[step.desired].(map[string]interface{}))
+			index++
+		}
+	}
+}
+
+// destinations aligns the current and desired forwardTo destinations of a route, ignoring their weights, and removes,
+// adds and reweights them by index
+func (b *jsonPatchBuilder) destinations(routePath string, cur, des map[string]interface{}) {
+	curDestinations, _ := jsonObjectAt(cur, "forwardTo")["destinations"].([]interface{})
+	desDestinations, _ := jsonObjectAt(des, "forwardTo")["destinations"].([]interface{})
+	if reflect.DeepEqual(curDestinations, desDestinations) {
+		return
+	}
+
+	path := routePath + "/forwardTo/destinations"
+	if name, ok := cur["name"]; ok {
+		b.add("test", routePath+"/name", name)
+	}
+	if curDestinations == nil {
+		b.add("add", path, desDestinations)
+		return
+	}
+
+	index := 0
+	for _, step := range alignJSONLists(curDestinations, desDestinations, withoutWeight) {
+		elementPath := fmt.Sprintf("%s/%d", path, index)
+		switch {
+		case step.desired < 0:
+			b.add("test", elementPath, curDestinations[step.current])
+			b.add("remove", elementPath, nil)
+		case step.current < 0:
+			b.add("add", elementPath, desDestinations[step.desired])
+			index++
+		default:
+			curWeight := curDestinations[step.current].(map[string]interface{})["weight"]
+			desWeight, desWeighted := desDestinations[step.desired].(map[string]interface{})["weight"]
+			switch {
+			case reflect.DeepEqual(curWeight, desWeight):
+			case !desWeighted:
+				b.add("test", elementPath, curDestinations[step.current])
+				b.add("remove", elementPath+"/weight", nil)
+			default:
+				b.add("test", elementPath, curDestinations[step.current])
+				b.add("add", elementPath+"/weight", desWeight)
+			}
+			index++
+		}
+	}
+}
+
+// jsonListStep pairs an element of the current list with an element of the desired list; -1 stands for a removed or
+// an added element
+type jsonListStep struct {
+	current int
+	desired int
+}
+
+// alignJSONLists returns the steps turning the current list into the desired one, keeping the longest common
+// subsequence of elements whose keys are equal
+func alignJSONLists(cur, des []interface{}, key func(interface{}) string) []jsonListStep {
+	curKeys := make([]string, len(cur))
+	for i, element := range cur {
+		curKeys[i] = key(element)
+	}
+	desKeys := make([]string, len(des))
+	for i, element := range des {
+		desKeys[i] = key(element)
+	}
+
+	// lengths[i][j] is the length of the longest common subsequence of curKeys[i:] and desKeys[j:]
+	lengths := make([][]int, len(cur)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(des)+1)
+	}
+	for i := len(cur) - 1; i >= 0; i-- {
+		for j := len(des) - 1; j >= 0; j-- {
+			if curKeys[i] == desKeys[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var steps []jsonListStep
+	i, j := 0, 0
+	for i < len(cur) || j < len(des) {
+		switch {
+		case i < len(cur) && j < len(des) && curKeys[i] == desKeys[j]:
+			steps = append(steps, jsonListStep{current: i, desired: j})
+			i++
+			j++
+		case j == len(des) || (i < len(cur) && lengths[i+1][j] >= lengths[i][j+1]):
+			steps = append(steps, jsonListStep{current: i, desired: -1})
+			i++
+		default:
+			steps = append(steps, jsonListStep{current: -1, desired: j})
+			j++
+		}
+	}
+	return steps
+}
+
+func withoutDestinations(route interface{}) string {
+	copied := copyJSONObject(route)
+	if forwardTo, ok := copied["forwardTo"].(map[string]interface{}); ok {
+		forwardTo = copyJSONObject(forwardTo)
+		delete(forwardTo, "destinations")
+		copied["forwardTo"] = forwardTo
+	}
+	data, _ := json.Marshal(copied)
+	return string(data)
+}
+
+func withoutWeight(destination interface{}) string {
+	copied := copyJSONObject(destination)
+	delete(copied, "weight")
+	data, _ := json.Marshal(copied)
+	return string(data)
+}
+
+// copyJSONObject returns a shallow copy of the object, or an empty object if it isn't one
+func copyJSONObject(value interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	object, _ := value.(map[string]interface{})
+	for k, v := range object {
+		copied[k] = v
+	}
+	return copied
+}
+
+func jsonObjectAt(object map[string]interface{}, field string) map[string]interface{} {
+	value, _ := object[field].(map[string]interface{})
+	return value
+}
+
+func toJSONObject(rt *networkv2.RouteTable) (map[string]interface{}, error) {
+	data, err := json.Marshal(rt)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func sortedKeys(objects ...map[string]interface{}) []string {
+	var keys []string
+	for _, object := range objects {
+		for key := range object {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeJSONPointer escapes a key for use in a JSON pointer, see RFC 6901
+func escapeJSONPointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -7,6 +7,9 @@ import (
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FieldManager owns the fields of the objects the plugin applies server-side
+const FieldManager = "argo-rollouts-gloo-plugin"
+
 func (c *routeTableClient) GetRouteTable(ctx context.Context, name string, namespace string) (*networkv2.RouteTable, error) {
 	rt := &networkv2.RouteTable{}
 	if err := c.client.Get(ctx, k8sclient.ObjectKey{Name: name, Namespace: namespace}, rt); err != nil {
@@ -30,3 +33,9 @@ func (c *routeTableClient) ListRouteTable(ctx context.Context, opts ...k8sclient
 func (c *routeTableClient) PatchRouteTable(ctx context.Context, obj *networkv2.RouteTable, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
 	return c.client.Patch(ctx, obj, patch, opts...)
 }
+
+func (c *routeTableClient) ApplyRouteTable(ctx context.Context, obj *networkv2.RouteTable, opts ...k8sclient.PatchOption) error {
+	obj.SetGroupVersionKind(networkv2.SchemeGroupVersion.WithKind("RouteTable"))
+	opts = append([]k8sclient.PatchOption{k8sclient.FieldOwner(FieldManager)}, opts...)
+	return c.client.Patch(ctx, obj, k8sclient.Apply, opts...)
+}
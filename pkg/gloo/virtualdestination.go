@@ -0,0 +1,40 @@
+package gloo
+
+import (
+	"context"
+
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func (c *virtualDestinationClient) GetVirtualDestination(ctx context.Context, name string, namespace string) (*networkv2.VirtualDestination, error) {
+	vd := &networkv2.VirtualDestination{}
+	if err := c.client.Get(ctx, k8sclient.ObjectKey{Name: name, Namespace: namespace}, vd); err != nil {
+		return nil, err
+	}
+	return vd, nil
+}
+
+func (c *virtualDestinationClient) ListVirtualDestination(ctx context.Context, opts ...k8sclient.ListOption) ([]*networkv2.VirtualDestination, error) {
+	vdl := &networkv2.VirtualDestinationList{}
+	if err := c.client.List(ctx, vdl, opts...); err != nil {
+		return nil, err
+	}
+	var result []*networkv2.VirtualDestination
+	for i := 0; i < len(vdl.Items); i++ {
+		result = append(result, &vdl.Items[i])
+	}
+	return result, nil
+}
+
+func (c *virtualDestinationClient) CreateVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.CreateOption) error {
+	return c.client.Create(ctx, obj, opts...)
+}
+
+func (c *virtualDestinationClient) PatchVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return c.client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *virtualDestinationClient) DeleteVirtualDestination(ctx context.Context, obj *networkv2.VirtualDestination, opts ...k8sclient.DeleteOption) error {
+	return c.client.Delete(ctx, obj, opts...)
+}
@@ -3,9 +3,15 @@ package mocks
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/gloo"
 	gloov2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -13,33 +19,231 @@ func NewGlooMockClient(routeTables []*gloov2.RouteTable) gloo.NetworkV2ClientSet
 	return &GlooMockClient{
 		rtClient: &glooMockRouteTableClient{
 			routeTables: routeTables,
+			rejected:    map[string]int{},
 		},
+		mpClient: &glooMockMirrorPolicyClient{},
+		vdClient: &glooMockVirtualDestinationClient{},
 	}
 }
 
 type GlooMockClient struct {
 	rtClient *glooMockRouteTableClient
+	mpClient *glooMockMirrorPolicyClient
+	vdClient *glooMockVirtualDestinationClient
+}
+
+// FailRouteTablePatches makes the next count RouteTable patches fail with a conflict, as if another writer changed the
+// RouteTable meanwhile
+func (c GlooMockClient) FailRouteTablePatches(count int) {
+	c.rtClient.conflicts = count
+}
+
+// RejectRouteTablePatches makes every patch and apply of the named RouteTable fail, once the given number of them
+// has been accepted
+func (c GlooMockClient) RejectRouteTablePatches(name string, after int) {
+	c.rtClient.rejected[name] = after
+}
+
+// ConflictRouteTableApplies makes the next applies fail because another field manager owns the routes
+func (c GlooMockClient) ConflictRouteTableApplies(count int) {
+	c.rtClient.fieldConflicts = count
+}
+
+// ServeRouteTableCopies makes the mock behave like an API server: RouteTables are read as copies, and only patched
+// objects are stored
+func (c GlooMockClient) ServeRouteTableCopies() {
+	c.rtClient.copies = true
+}
+
+// RouteTablePatches returns the data of the RouteTable patches and applies sent so far, including the failed ones
+func (c GlooMockClient) RouteTablePatches() []string {
+	return c.rtClient.patches
 }
 
 func (c GlooMockClient) RouteTables() gloo.RouteTableClient {
 	return c.rtClient
 }
 
+func (c GlooMockClient) MirrorPolicies() gloo.MirrorPolicyClient {
+	return c.mpClient
+}
+
+func (c GlooMockClient) VirtualDestinations() gloo.VirtualDestinationClient {
+	return c.vdClient
+}
+
 type glooMockRouteTableClient struct {
-	routeTables []*gloov2.RouteTable
+	routeTables    []*gloov2.RouteTable
+	conflicts      int
+	fieldConflicts int
+	patches        []string
+	copies         bool
+	rejected       map[string]int
+}
+
+func (c *glooMockRouteTableClient) read(rt *gloov2.RouteTable) *gloov2.RouteTable {
+	if !c.copies {
+		return rt
+	}
+	return rt.DeepCopy()
+}
+
+func (c *glooMockRouteTableClient) store(obj *gloov2.RouteTable) {
+	if !c.copies {
+		return
+	}
+	for _, rt := range c.routeTables {
+		if rt.Name == obj.Name && rt.Namespace == obj.Namespace {
+			obj.DeepCopyInto(rt)
+		}
+	}
+}
+
+func (c *glooMockRouteTableClient) GetRouteTable(ctx context.Context, name string, namespace string) (*gloov2.RouteTable, error) {
+	for _, rt := range c.routeTables {
+		if rt.Name == name && rt.Namespace == namespace {
+			return c.read(rt), nil
+		}
+	}
+	// test cases with a single table don't need to name it correctly
+	if len(c.routeTables) == 1 {
+		return c.read(c.routeTables[0]), nil
+	}
+	return nil, k8serrors.NewNotFound(schema.GroupResource{Group: gloov2.SchemeGroupVersion.Group, Resource: "routetables"}, name)
+}
+
+func (c *glooMockRouteTableClient) PatchRouteTable(ctx context.Context, obj *gloov2.RouteTable, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	if err := c.write(obj, patch); err != nil {
+		return err
+	}
+	c.store(obj)
+	return nil
+}
+
+func (c *glooMockRouteTableClient) ApplyRouteTable(ctx context.Context, obj *gloov2.RouteTable, opts ...k8sclient.PatchOption) error {
+	if err := c.write(obj, k8sclient.Apply); err != nil {
+		return err
+	}
+	if c.fieldConflicts > 0 {
+		c.fieldConflicts--
+		return k8serrors.NewApplyConflict([]metav1.StatusCause{{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "argocd-controller"`,
+			Field:   ".spec.http",
+		}}, `Apply failed with 1 conflict: conflict with "argocd-controller": .spec.http`)
+	}
+	// the applied object only has the fields owned by the plugin, so it isn't stored, but like in the response of an
+	// API server its resourceVersion is the new one
+	if version, err := strconv.Atoi(obj.ResourceVersion); err == nil {
+		obj.ResourceVersion = strconv.Itoa(version + 1)
+	}
+	return nil
 }
 
-func (c glooMockRouteTableClient) GetRouteTable(ctx context.Context, name string, namespace string) (*gloov2.RouteTable, error) {
-	if len(c.routeTables) > 0 {
-		return c.routeTables[0], nil
+// write records the patch, failing for rejected RouteTables and with a conflict as long as conflicts are left
+func (c *glooMockRouteTableClient) write(obj *gloov2.RouteTable, patch k8sclient.Patch) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
 	}
-	return nil, fmt.Errorf("routeTable not found: %s:%s", namespace, name)
+	c.patches = append(c.patches, string(data))
+	if accepted, ok := c.rejected[obj.Name]; ok {
+		if accepted == 0 {
+			return k8serrors.NewForbidden(schema.GroupResource{Group: gloov2.SchemeGroupVersion.Group, Resource: "routetables"}, obj.Name, fmt.Errorf("rejected"))
+		}
+		c.rejected[obj.Name]--
+	}
+	if c.conflicts > 0 {
+		c.conflicts--
+		return k8serrors.NewConflict(schema.GroupResource{Group: gloov2.SchemeGroupVersion.Group, Resource: "routetables"}, obj.Name, fmt.Errorf("the object has been modified"))
+	}
+	return nil
+}
+
+func (c *glooMockRouteTableClient) ListRouteTable(ctx context.Context, opts ...k8sclient.ListOption) ([]*gloov2.RouteTable, error) {
+	listOpts := &k8sclient.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	var result []*gloov2.RouteTable
+	for _, rt := range c.routeTables {
+		if listOpts.Namespace != "" && rt.Namespace != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(rt.Labels)) {
+			continue
+		}
+		result = append(result, c.read(rt))
+	}
+	return result, nil
+}
+
+type glooMockMirrorPolicyClient struct {
+	mirrorPolicies []*trafficv2.MirrorPolicy
+}
+
+func (c *glooMockMirrorPolicyClient) GetMirrorPolicy(ctx context.Context, name string, namespace string) (*trafficv2.MirrorPolicy, error) {
+	for _, mp := range c.mirrorPolicies {
+		if mp.Name == name && mp.Namespace == namespace {
+			return mp, nil
+		}
+	}
+	return nil, k8serrors.NewNotFound(schema.GroupResource{Group: trafficv2.SchemeGroupVersion.Group, Resource: "mirrorpolicies"}, name)
 }
 
-func (c glooMockRouteTableClient) PatchRouteTable(ctx context.Context, obj *gloov2.RouteTable, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+func (c *glooMockMirrorPolicyClient) ListMirrorPolicy(ctx context.Context, opts ...k8sclient.ListOption) ([]*trafficv2.MirrorPolicy, error) {
+	return c.mirrorPolicies, nil
+}
+
+func (c *glooMockMirrorPolicyClient) CreateMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.CreateOption) error {
+	c.mirrorPolicies = append(c.mirrorPolicies, obj)
 	return nil
 }
 
-func (c glooMockRouteTableClient) ListRouteTable(ctx context.Context, opts ...k8sclient.ListOption) ([]*gloov2.RouteTable, error) {
-	return c.routeTables, nil
+func (c *glooMockMirrorPolicyClient) PatchMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return nil
+}
+
+func (c *glooMockMirrorPolicyClient) DeleteMirrorPolicy(ctx context.Context, obj *trafficv2.MirrorPolicy, opts ...k8sclient.DeleteOption) error {
+	for i, mp := range c.mirrorPolicies {
+		if mp.Name == obj.Name && mp.Namespace == obj.Namespace {
+			c.mirrorPolicies = append(c.mirrorPolicies[:i], c.mirrorPolicies[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+type glooMockVirtualDestinationClient struct {
+	virtualDestinations []*gloov2.VirtualDestination
+}
+
+func (c *glooMockVirtualDestinationClient) GetVirtualDestination(ctx context.Context, name string, namespace string) (*gloov2.VirtualDestination, error) {
+	for _, vd := range c.virtualDestinations {
+		if vd.Name == name && vd.Namespace == namespace {
+			return vd, nil
+		}
+	}
+	return nil, k8serrors.NewNotFound(schema.GroupResource{Group: gloov2.SchemeGroupVersion.Group, Resource: "virtualdestinations"}, name)
+}
+
+func (c *glooMockVirtualDestinationClient) ListVirtualDestination(ctx context.Context, opts ...k8sclient.ListOption) ([]*gloov2.VirtualDestination, error) {
+	return c.virtualDestinations, nil
+}
+
+func (c *glooMockVirtualDestinationClient) CreateVirtualDestination(ctx context.Context, obj *gloov2.VirtualDestination, opts ...k8sclient.CreateOption) error {
+	c.virtualDestinations = append(c.virtualDestinations, obj)
+	return nil
+}
+
+func (c *glooMockVirtualDestinationClient) PatchVirtualDestination(ctx context.Context, obj *gloov2.VirtualDestination, patch k8sclient.Patch, opts ...k8sclient.PatchOption) error {
+	return nil
+}
+
+func (c *glooMockVirtualDestinationClient) DeleteVirtualDestination(ctx context.Context, obj *gloov2.VirtualDestination, opts ...k8sclient.DeleteOption) error {
+	for i, vd := range c.virtualDestinations {
+		if vd.Name == obj.Name && vd.Namespace == obj.Namespace {
+			c.virtualDestinations = append(c.virtualDestinations[:i], c.virtualDestinations[i+1:]...)
+			return nil
+		}
+	}
+	return nil
 }
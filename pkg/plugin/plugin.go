@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"regexp"
 	"slices"
 	"strings"
@@ -15,6 +16,9 @@ import (
 	"github.com/sirupsen/logrus"
 	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
 	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	"google.golang.org/protobuf/proto"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -23,6 +27,18 @@ const (
 	Type                       = "GlooPlatformAPI"
 	GlooPlatformAPIUpdateError = "GlooPlatformAPIUpdateError"
 	PluginName                 = "solo-io/glooplatform"
+	// AdditionalDestinationsAnnotation maps the rollouts the plugin added RouteTable destinations for onto their additional services
+	AdditionalDestinationsAnnotation = "glooplatform.argoproj.io/additional-destinations"
+	// CanaryDestinationsAnnotation maps the routes the plugin added a canary destination to onto their authored stable weight
+	CanaryDestinationsAnnotation = "glooplatform.argoproj.io/canary-destinations"
+	// DryRunEnvVar turns on the dry run of the plugin for all rollouts when set to true
+	DryRunEnvVar = "GLOOPLATFORM_PLUGIN_DRY_RUN"
+	// WriteModePatch merge patches the changes to RouteTables
+	WriteModePatch = "patch"
+	// WriteModeApply applies the routes and annotations the plugin manages server-side, as the gloo.FieldManager
+	WriteModeApply = "apply"
+	// WriteModeJSONPatch JSON patches the changed routes and destination weights by index, testing the indexes first
+	WriteModeJSONPatch = "jsonPatch"
 )
 
 type RpcPlugin struct {
@@ -31,27 +47,214 @@ type RpcPlugin struct {
 	// TestRouteTable *networkv2.RouteTable
 	LogCtx *logrus.Entry
 	Client gloo.NetworkV2ClientSet
+	// DryRun logs the changes the plugin would make for any rollout instead of making them, see DryRunEnvVar
+	DryRun bool
 }
 
 type GlooPlatformAPITrafficRouting struct {
 	RouteTableSelector *SimpleObjectSelector `json:"routeTableSelector" protobuf:"bytes,1,name=routeTableSelector"`
 	RouteSelector      *SimpleRouteSelector  `json:"routeSelector" protobuf:"bytes,2,name=routeSelector"`
+	// PodTemplateHashSubsets routes to the stable and canary pods of the stable service using pod-template-hash subsets
+	PodTemplateHashSubsets bool `json:"podTemplateHashSubsets,omitempty" protobuf:"varint,4,opt,name=podTemplateHashSubsets"`
+	// DestinationCluster only matches destinations referencing services in this workload cluster
+	DestinationCluster string `json:"destinationCluster,omitempty" protobuf:"bytes,5,opt,name=destinationCluster"`
+	// StableDestinationMatcher selects the stable or active destinations instead of the stable or active service
+	StableDestinationMatcher *GlooDestinationMatcher `json:"stableDestinationMatcher,omitempty" protobuf:"bytes,6,opt,name=stableDestinationMatcher"`
+	// CanaryDestinationMatcher selects the canary or preview destinations instead of the canary or preview service
+	CanaryDestinationMatcher *GlooDestinationMatcher `json:"canaryDestinationMatcher,omitempty" protobuf:"bytes,7,opt,name=canaryDestinationMatcher"`
+	// CanaryVirtualDestinations clones stable VirtualDestinations into canary VirtualDestinations selecting the canary or preview service
+	CanaryVirtualDestinations bool `json:"canaryVirtualDestinations,omitempty" protobuf:"varint,8,opt,name=canaryVirtualDestinations"`
+	// FollowDelegation also matches the routes of the RouteTables the selected RouteTables delegate to, recursively
+	FollowDelegation bool `json:"followDelegation,omitempty" protobuf:"varint,9,opt,name=followDelegation"`
+	// Targets are further RouteTable and route selector pairs, matched and patched together with the ones above
+	Targets []*GlooPlatformAPITarget `json:"targets,omitempty" protobuf:"bytes,10,rep,name=targets"`
+	// WriteMode is how changes are written to RouteTables: "patch" (default), "apply" or "jsonPatch"
+	WriteMode string `json:"writeMode,omitempty" protobuf:"bytes,11,opt,name=writeMode"`
+	// Transactional undoes the changes written to the RouteTables already updated when updating a further matched RouteTable fails
+	Transactional bool `json:"transactional,omitempty" protobuf:"varint,12,opt,name=transactional"`
+	// DryRun logs the changes the plugin would make instead of making them
+	DryRun bool `json:"dryRun,omitempty" protobuf:"varint,13,opt,name=dryRun"`
+}
+
+// GlooPlatformAPITarget selects RouteTables and routes independently of the other targets
+type GlooPlatformAPITarget struct {
+	RouteTableSelector *SimpleObjectSelector `json:"routeTableSelector" protobuf:"bytes,1,name=routeTableSelector"`
+	RouteSelector      *SimpleRouteSelector  `json:"routeSelector,omitempty" protobuf:"bytes,2,opt,name=routeSelector"`
+	// WeightPercentage scales the canary and additional destination weights of the target's routes, in percent
+	WeightPercentage *int32 `json:"weightPercentage,omitempty" protobuf:"varint,3,opt,name=weightPercentage"`
+}
+
+// targets returns the target of the top level selectors, if any, followed by the other targets
+func (g *GlooPlatformAPITrafficRouting) targets() []*GlooPlatformAPITarget {
+	var targets []*GlooPlatformAPITarget
+	if g.RouteTableSelector != nil {
+		targets = append(targets, &GlooPlatformAPITarget{
+			RouteTableSelector: g.RouteTableSelector,
+			RouteSelector:      g.RouteSelector,
+		})
+	}
+	return append(targets, g.Targets...)
 }
 
 type SimpleObjectSelector struct {
 	Labels    map[string]string `json:"labels" protobuf:"bytes,1,name=labels"`
 	Name      string            `json:"name" protobuf:"bytes,2,name=name"`
 	Namespace string            `json:"namespace" protobuf:"bytes,3,name=namespace"`
+	// MatchExpressions are ANDed with the labels like in a Kubernetes label selector
+	MatchExpressions []metav1.LabelSelectorRequirement `json:"matchExpressions,omitempty" protobuf:"bytes,4,rep,name=matchExpressions"`
+	// Namespaces selects objects in any of these namespaces in addition to the namespace
+	Namespaces []string `json:"namespaces,omitempty" protobuf:"bytes,5,rep,name=namespaces"`
+	// AllNamespaces selects objects in all namespaces
+	AllNamespaces bool `json:"allNamespaces,omitempty" protobuf:"varint,6,opt,name=allNamespaces"`
+	// Hosts selects RouteTables serving any of these hosts
+	Hosts []string `json:"hosts,omitempty" protobuf:"bytes,7,rep,name=hosts"`
+	// VirtualGateways selects RouteTables attached to any of these VirtualGateways; an empty namespace or cluster matches any
+	VirtualGateways []*solov2.ObjectReference `json:"virtualGateways,omitempty" protobuf:"bytes,8,rep,name=virtualGateways"`
+}
+
+// namespaces returns the namespaces to look for objects in; metav1.NamespaceAll stands for all namespaces
+func (s *SimpleObjectSelector) namespaces() []string {
+	if s.AllNamespaces {
+		return []string{metav1.NamespaceAll}
+	}
+	var namespaces []string
+	for _, namespace := range append([]string{s.Namespace}, s.Namespaces...) {
+		if namespace != "" && !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// selectsRouteTable reports whether the RouteTable serves any of the selected hosts and is attached to any of the
+// selected VirtualGateways
+func (s *SimpleObjectSelector) selectsRouteTable(rt *networkv2.RouteTable) bool {
+	if len(s.Hosts) > 0 && !slices.ContainsFunc(rt.Spec.GetHosts(), func(rtHost string) bool {
+		return slices.ContainsFunc(s.Hosts, func(host string) bool {
+			return hostMatches(rtHost, host)
+		})
+	}) {
+		return false
+	}
+	if len(s.VirtualGateways) > 0 && !slices.ContainsFunc(rt.Spec.GetVirtualGateways(), func(vg *solov2.ObjectReference) bool {
+		return slices.ContainsFunc(s.VirtualGateways, func(selected *solov2.ObjectReference) bool {
+			namespace := vg.GetNamespace()
+			if namespace == "" {
+				namespace = rt.Namespace
+			}
+			return strings.EqualFold(vg.GetName(), selected.GetName()) &&
+				(selected.GetNamespace() == "" || strings.EqualFold(namespace, selected.GetNamespace())) &&
+				(selected.GetCluster() == "" || strings.EqualFold(vg.GetCluster(), selected.GetCluster()))
+		})
+	}) {
+		return false
+	}
+	return true
+}
+
+// hostMatches reports whether a RouteTable host, which may be a wildcard like *.example.com, matches the host
+func hostMatches(rtHost, host string) bool {
+	if rtHost == "*" || strings.EqualFold(rtHost, host) {
+		return true
+	}
+	suffix, wildcard := strings.CutPrefix(rtHost, "*")
+	return wildcard && len(host) > len(suffix) && strings.HasSuffix(strings.ToLower(host), strings.ToLower(suffix))
 }
 
 type SimpleRouteSelector struct {
 	Labels map[string]string `json:"labels" protobuf:"bytes,1,name=labels"`
 	Name   string            `json:"name" protobuf:"bytes,2,name=name"`
+	// SniHosts selects tls routes with a matcher for any of these SNI hosts
+	SniHosts []string `json:"sniHosts,omitempty" protobuf:"bytes,3,rep,name=sniHosts"`
+	// MatchExpressions are ANDed with the labels like in a Kubernetes label selector
+	MatchExpressions []metav1.LabelSelectorRequirement `json:"matchExpressions,omitempty" protobuf:"bytes,4,rep,name=matchExpressions"`
+}
+
+// String describes the selector for error messages
+func (s *SimpleObjectSelector) String() string {
+	var criteria []string
+	if s.Name != "" {
+		criteria = append(criteria, fmt.Sprintf("name %s", s.Name))
+	}
+	if s.AllNamespaces {
+		criteria = append(criteria, "all namespaces")
+	} else {
+		criteria = append(criteria, fmt.Sprintf("namespaces %s", strings.Join(s.namespaces(), ",")))
+	}
+	if len(s.Labels) > 0 || len(s.MatchExpressions) > 0 {
+		criteria = append(criteria, describeLabelSelector(s.Labels, s.MatchExpressions))
+	}
+	if len(s.Hosts) > 0 {
+		criteria = append(criteria, fmt.Sprintf("hosts %s", strings.Join(s.Hosts, ",")))
+	}
+	if len(s.VirtualGateways) > 0 {
+		var virtualGateways []string
+		for _, vg := range s.VirtualGateways {
+			virtualGateways = append(virtualGateways, strings.Trim(fmt.Sprintf("%s.%s", vg.GetNamespace(), vg.GetName()), "."))
+		}
+		criteria = append(criteria, fmt.Sprintf("virtual gateways %s", strings.Join(virtualGateways, ",")))
+	}
+	return strings.Join(criteria, ", ")
+}
+
+// String describes the selector for error messages
+func (s *SimpleRouteSelector) String() string {
+	var criteria []string
+	if s.Name != "" {
+		criteria = append(criteria, fmt.Sprintf("name %s", s.Name))
+	}
+	if s.selectsByLabels() {
+		criteria = append(criteria, describeLabelSelector(s.Labels, s.MatchExpressions))
+	}
+	if len(s.SniHosts) > 0 {
+		criteria = append(criteria, fmt.Sprintf("SNI hosts %s", strings.Join(s.SniHosts, ",")))
+	}
+	if len(criteria) == 0 {
+		return "all routes"
+	}
+	return strings.Join(criteria, ", ")
 }
 
+func describeLabelSelector(matchLabels map[string]string, matchExpressions []metav1.LabelSelectorRequirement) string {
+	selector, err := labelSelector(matchLabels, matchExpressions)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("labels %s", selector)
+}
+
+// selectsByLabels reports whether the selector has labels or match expressions
+func (s *SimpleRouteSelector) selectsByLabels() bool {
+	return len(s.Labels) > 0 || len(s.MatchExpressions) > 0
+}
+
+// labelSelector parses the labels and match expressions of a selector into a Kubernetes label selector
+func labelSelector(matchLabels map[string]string, matchExpressions []metav1.LabelSelectorRequirement) (labels.Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels:      matchLabels,
+		MatchExpressions: matchExpressions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector: %s", err)
+	}
+	return selector, nil
+}
+
+// GlooDestinationMatcher matches the destinations satisfying all of its criteria
 type GlooDestinationMatcher struct {
-	// Regexp *GlooDestinationMatcherRegexp `json:"regexp" protobuf:"bytes,1,name=regexp"`
-	Ref *solov2.ObjectReference `json:"ref" protobuf:"bytes,2,name=ref"`
+	Regexp *GlooDestinationMatcherRegexp `json:"regexp,omitempty" protobuf:"bytes,1,opt,name=regexp"`
+	// Ref matches the destinations referencing this object; the namespace defaults to the rollout namespace and the
+	// cluster to the destinationCluster
+	Ref *solov2.ObjectReference `json:"ref,omitempty" protobuf:"bytes,2,opt,name=ref"`
+	// Kind matches the destinations of this kind: Service, VirtualDestination or ExternalService
+	Kind string `json:"kind,omitempty" protobuf:"bytes,3,opt,name=kind"`
+}
+
+// GlooDestinationMatcherRegexp matches the destinations whose ref matches all of its regular expressions in full
+type GlooDestinationMatcherRegexp struct {
+	Name      string `json:"name,omitempty" protobuf:"bytes,1,opt,name=name"`
+	Namespace string `json:"namespace,omitempty" protobuf:"bytes,2,opt,name=namespace"`
+	Cluster   string `json:"cluster,omitempty" protobuf:"bytes,3,opt,name=cluster"`
 }
 
 type GlooMatchedRouteTable struct {
@@ -63,6 +266,170 @@ type GlooMatchedRouteTable struct {
 	TCPRoutes []*GlooMatchedTCPRoutes
 	// matched tls routes within the routetable
 	TLSRoutes []*GlooMatchedTLSRoutes
+	// where the destinations of the rollout services are expected
+	scope destinationScope
+	// weight percentage of the target the route table was matched by
+	weightPercentage *int32
+	// rollout and configs of the targets the route table was matched by, to match its routes again after a conflict
+	rollout       *v1alpha1.Rollout
+	targetConfigs []*GlooPlatformAPITrafficRouting
+}
+
+// destinationScope is the namespace and optional workload cluster of the services of a rollout
+type destinationScope struct {
+	namespace string
+	cluster   string
+}
+
+func newDestinationScope(rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) destinationScope {
+	return destinationScope{
+		namespace: rollout.Namespace,
+		cluster:   trafficConfig.DestinationCluster,
+	}
+}
+
+// refersTo reports whether the destination references the named service within the scope. Destinations without a
+// namespace reference a service in the namespace of their RouteTable.
+func (s destinationScope) refersTo(dest *solov2.DestinationReference, serviceName string, routeTableNamespace string) bool {
+	ref := dest.GetRef()
+	if ref == nil || !strings.EqualFold(ref.GetName(), serviceName) {
+		return false
+	}
+	namespace := ref.GetNamespace()
+	if namespace == "" {
+		namespace = routeTableNamespace
+	}
+	if !strings.EqualFold(namespace, s.namespace) {
+		return false
+	}
+	return s.cluster == "" || strings.EqualFold(ref.GetCluster(), s.cluster)
+}
+
+// validate checks that the regular expressions compile and the kind is known
+func (m *GlooDestinationMatcher) validate() error {
+	if m.Regexp != nil {
+		for _, expr := range []string{m.Regexp.Name, m.Regexp.Namespace, m.Regexp.Cluster} {
+			if _, err := regexp.Compile(expr); err != nil {
+				return fmt.Errorf("invalid destination matcher regexp %q: %s", expr, err)
+			}
+		}
+	}
+	if m.Kind != "" {
+		if _, ok := parseDestinationKind(m.Kind); !ok {
+			return fmt.Errorf("invalid destination matcher kind %s", m.Kind)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the destination satisfies all criteria of the matcher. Destinations without a namespace
+// reference an object in the namespace of their RouteTable.
+func (m *GlooDestinationMatcher) matches(dest *solov2.DestinationReference, scope destinationScope, routeTableNamespace string) bool {
+	ref := dest.GetRef()
+	if ref == nil {
+		return false
+	}
+	if m.Kind != "" {
+		if kind, _ := parseDestinationKind(m.Kind); dest.GetKind() != kind {
+			return false
+		}
+	}
+	if m.Ref != nil {
+		refScope := scope
+		if m.Ref.GetNamespace() != "" {
+			refScope.namespace = m.Ref.GetNamespace()
+		}
+		if m.Ref.GetCluster() != "" {
+			refScope.cluster = m.Ref.GetCluster()
+		}
+		if !refScope.refersTo(dest, m.Ref.GetName(), routeTableNamespace) {
+			return false
+		}
+	}
+	if m.Regexp != nil {
+		namespace := ref.GetNamespace()
+		if namespace == "" {
+			namespace = routeTableNamespace
+		}
+		if !matchesFully(m.Regexp.Name, ref.GetName()) || !matchesFully(m.Regexp.Namespace, namespace) || !matchesFully(m.Regexp.Cluster, ref.GetCluster()) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesFully reports whether the regular expression matches all of the value; an empty expression matches anything
+func matchesFully(expr string, value string) bool {
+	if expr == "" {
+		return true
+	}
+	matched, err := regexp.MatchString("^(?:"+expr+")$", value)
+	return err == nil && matched
+}
+
+// parseDestinationKind accepts both the Gloo enum names, e.g. VIRTUAL_DESTINATION, and kind names, e.g. VirtualDestination
+func parseDestinationKind(kind string) (solov2.DestinationKind, bool) {
+	normalized := strings.ReplaceAll(kind, "_", "")
+	for value, name := range solov2.DestinationKind_name {
+		if strings.EqualFold(strings.ReplaceAll(name, "_", ""), normalized) {
+			return solov2.DestinationKind(value), true
+		}
+	}
+	return 0, false
+}
+
+// isStableOrActive reports whether the destination is a stable or active destination of the rollout
+func (g *GlooMatchedRouteTable) isStableOrActive(dest *solov2.DestinationReference, rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) bool {
+	if trafficConfig.StableDestinationMatcher != nil {
+		return trafficConfig.StableDestinationMatcher.matches(dest, g.scope, g.RouteTable.Namespace)
+	}
+	return g.scope.refersTo(dest, getStableOrActiveService(rollout), g.RouteTable.Namespace)
+}
+
+// isCanaryOrPreview reports whether the destination is a canary or preview destination of the rollout
+func (g *GlooMatchedRouteTable) isCanaryOrPreview(dest *solov2.DestinationReference, rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) bool {
+	if trafficConfig.CanaryDestinationMatcher != nil {
+		return trafficConfig.CanaryDestinationMatcher.matches(dest, g.scope, g.RouteTable.Namespace)
+	}
+	return g.scope.refersTo(dest, getCanaryOrPreviewService(rollout), g.RouteTable.Namespace)
+}
+
+// scaleWeight scales a canary or additional destination weight by the weight percentage of the route table's target
+func (g *GlooMatchedRouteTable) scaleWeight(weight int32) int32 {
+	if g.weightPercentage == nil {
+		return weight
+	}
+	return weight * *g.weightPercentage / 100
+}
+
+// merge adds the routes matched in another match of the same RouteTable
+func (g *GlooMatchedRouteTable) merge(other *GlooMatchedRouteTable) {
+	g.targetConfigs = append(g.targetConfigs, other.targetConfigs...)
+	for _, route := range other.HttpRoutes {
+		if !slices.ContainsFunc(g.HttpRoutes, func(matched *GlooMatchedHttpRoutes) bool { return matched.HttpRoute == route.HttpRoute }) {
+			g.HttpRoutes = append(g.HttpRoutes, route)
+		}
+	}
+	for _, route := range other.TCPRoutes {
+		if !slices.ContainsFunc(g.TCPRoutes, func(matched *GlooMatchedTCPRoutes) bool { return matched.TCPRoute == route.TCPRoute }) {
+			g.TCPRoutes = append(g.TCPRoutes, route)
+		}
+	}
+	for _, route := range other.TLSRoutes {
+		if !slices.ContainsFunc(g.TLSRoutes, func(matched *GlooMatchedTLSRoutes) bool { return matched.TLSRoute == route.TLSRoute }) {
+			g.TLSRoutes = append(g.TLSRoutes, route)
+		}
+	}
+}
+
+// findDestination returns the destination referencing the named service within the scope of the route table
+func (g *GlooMatchedRouteTable) findDestination(destinations []*solov2.DestinationReference, serviceName string) *solov2.DestinationReference {
+	for _, dest := range destinations {
+		if g.scope.refersTo(dest, serviceName, g.RouteTable.Namespace) {
+			return dest
+		}
+	}
+	return nil
 }
 
 type GlooDestinations struct {
@@ -91,6 +458,81 @@ type GlooMatchedTCPRoutes struct {
 	Destinations []*GlooDestinations
 }
 
+// glooWeightedRoute is a matched route of any protocol along with the destinations of its forwardTo action
+type glooWeightedRoute struct {
+	// route name used in logs and messages
+	name         string
+	destinations *GlooDestinations
+	forwardTo    *[]*solov2.DestinationReference
+}
+
+// weightedRoutes returns the matched routes of all protocols whose destinations are weighted
+func (g *GlooMatchedRouteTable) weightedRoutes() []*glooWeightedRoute {
+	var routes []*glooWeightedRoute
+	names := map[string]int{}
+	// the plugin keeps records of routes by name, so a route without one is named after its matchers rather than its
+	// index, which changes when the plugin inserts header or mirror routes before it
+	uniqueName := func(name string) string {
+		names[name]++
+		if names[name] > 1 {
+			return fmt.Sprintf("%s-%d", name, names[name])
+		}
+		return name
+	}
+	for _, matchedHttpRoute := range g.HttpRoutes {
+		if matchedHttpRoute.Destinations == nil {
+			continue
+		}
+		name := httpRouteName(matchedHttpRoute.HttpRoute)
+		routes = append(routes, &glooWeightedRoute{
+			name:         uniqueName(name),
+			destinations: matchedHttpRoute.Destinations,
+			forwardTo:    &matchedHttpRoute.HttpRoute.GetForwardTo().Destinations,
+		})
+	}
+	for _, matchedTcpRoute := range g.TCPRoutes {
+		name := unnamedRouteName("tcp", matchedTcpRoute.TCPRoute.GetMatchers())
+		for _, destinations := range matchedTcpRoute.Destinations {
+			routes = append(routes, &glooWeightedRoute{
+				name:         uniqueName(name),
+				destinations: destinations,
+				forwardTo:    &matchedTcpRoute.TCPRoute.GetForwardTo().Destinations,
+			})
+		}
+	}
+	for _, matchedTlsRoute := range g.TLSRoutes {
+		name := unnamedRouteName("tls", matchedTlsRoute.TLSRoute.GetMatchers())
+		for _, destinations := range matchedTlsRoute.Destinations {
+			routes = append(routes, &glooWeightedRoute{
+				name:         uniqueName(name),
+				destinations: destinations,
+				forwardTo:    &matchedTlsRoute.TLSRoute.GetForwardTo().Destinations,
+			})
+		}
+	}
+	return routes
+}
+
+// httpRouteName returns the name of the http route, or the name recorded for it when it has none
+func httpRouteName(route *networkv2.HTTPRoute) string {
+	if route.GetName() != "" {
+		return route.GetName()
+	}
+	return unnamedRouteName("http", route.GetMatchers())
+}
+
+// unnamedRouteName names a route without a name by its protocol and a hash of its matchers, which the plugin doesn't
+// change
+func unnamedRouteName[T proto.Message](protocol string, matchers []T) string {
+	hash := fnv.New32a()
+	for _, matcher := range matchers {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(matcher)
+		fmt.Fprintf(hash, "%d:", len(data))
+		hash.Write(data)
+	}
+	return fmt.Sprintf("%s#%08x", protocol, hash.Sum32())
+}
+
 func (r *RpcPlugin) InitPlugin() pluginTypes.RpcError {
 	if r.IsTest {
 		return pluginTypes.RpcError{}
@@ -106,7 +548,27 @@ func (r *RpcPlugin) InitPlugin() pluginTypes.RpcError {
 }
 
 func (r *RpcPlugin) UpdateHash(rollout *v1alpha1.Rollout, canaryHash, stableHash string, additionalDestinations []v1alpha1.WeightDestination) pluginTypes.RpcError {
-	return pluginTypes.RpcError{}
+	ctx := context.TODO()
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+	if !glooPluginConfig.PodTemplateHashSubsets {
+		// destinations are told apart by service name, the hashes don't matter
+		return pluginTypes.RpcError{}
+	}
+
+	// get the matched routetables
+	matchedRts, err := r.getRouteTables(ctx, rollout, glooPluginConfig)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	return r.handleUpdateHash(ctx, matchedRts, canaryHash, stableHash)
 }
 
 func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination) pluginTypes.RpcError {
@@ -126,13 +588,14 @@ func (r *RpcPlugin) SetWeight(rollout *v1alpha1.Rollout, desiredWeight int32, ad
 		}
 	}
 
-	if rollout.Spec.Strategy.Canary != nil {
-		return r.handleCanary(ctx, rollout, desiredWeight, additionalDestinations, glooPluginConfig, matchedRts)
-	} else if rollout.Spec.Strategy.BlueGreen != nil {
-		return r.handleBlueGreen(rollout)
+	if len(matchedRts) == 0 {
+		// shifting no traffic must not let the rollout progress
+		return pluginTypes.RpcError{
+			ErrorString: noMatchingRoutesError(rollout, glooPluginConfig).Error(),
+		}
 	}
 
-	return pluginTypes.RpcError{}
+	return r.handleCanary(ctx, rollout, desiredWeight, additionalDestinations, glooPluginConfig, matchedRts)
 }
 
 func (r *RpcPlugin) SetHeaderRoute(rollout *v1alpha1.Rollout, headerRouting *v1alpha1.SetHeaderRoute) pluginTypes.RpcError {
@@ -153,31 +616,99 @@ func (r *RpcPlugin) SetHeaderRoute(rollout *v1alpha1.Rollout, headerRouting *v1a
 		}
 	}
 	if len(matchedRts) == 0 {
-		// nothing to update, don't bother computing things
 		return pluginTypes.RpcError{
-			ErrorString: "unable to find qualifying RouteTables", // TODO: include the selection criteria which failed (may require update to getRouteTables to do nicely)
+			ErrorString: noMatchingRoutesError(rollout, glooPluginConfig).Error(),
 		}
 	}
 
-	return r.handleHeaderRoute(ctx, matchedRts, buildGlooMatches(headerRouting), headerRouting.Name, rollout.Spec.Strategy.Canary.CanaryService)
+	// Argo Rollouts removes a header route by setting it without a match
+	if headerRouting.Match == nil {
+		if err := r.removeRoutes(ctx, matchedRts, []string{headerRouting.Name}); err != nil {
+			return pluginTypes.RpcError{
+				ErrorString: err.Error(),
+			}
+		}
+		return pluginTypes.RpcError{}
+	}
+	matcher, err := buildGlooMatches(headerRouting)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+	return r.handleHeaderRoute(ctx, rollout, glooPluginConfig, matchedRts, matcher, headerRouting.Name)
 }
 
 func (r *RpcPlugin) SetMirrorRoute(rollout *v1alpha1.Rollout, setMirrorRoute *v1alpha1.SetMirrorRoute) pluginTypes.RpcError {
-	return pluginTypes.RpcError{}
+	r.LogCtx.Debugln("SetMirrorRoute")
+	ctx := context.TODO()
+
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+	// get the matched routetables
+	matchedRts, err := r.getRouteTables(ctx, rollout, glooPluginConfig)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	if setMirrorRoute.Match == nil {
+		// a SetMirrorRoute without matches removes the mirror route
+		err := errors.Join(r.removeRoutes(ctx, matchedRts, []string{setMirrorRoute.Name}), r.removeMirrorPolicies(ctx, matchedRts, []string{setMirrorRoute.Name}, r.isDryRun(glooPluginConfig)))
+		if err != nil {
+			return pluginTypes.RpcError{
+				ErrorString: err.Error(),
+			}
+		}
+		return pluginTypes.RpcError{}
+	}
+
+	if len(matchedRts) == 0 {
+		return pluginTypes.RpcError{
+			ErrorString: noMatchingRoutesError(rollout, glooPluginConfig).Error(),
+		}
+	}
+
+	return r.handleMirrorRoute(ctx, rollout, glooPluginConfig, matchedRts, setMirrorRoute)
 }
 
 func (r *RpcPlugin) VerifyWeight(rollout *v1alpha1.Rollout, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination) (pluginTypes.RpcVerified, pluginTypes.RpcError) {
-	return pluginTypes.Verified, pluginTypes.RpcError{}
-}
+	ctx := context.TODO()
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
 
-func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.RpcError {
-	if !slices.ContainsFunc(rollout.Spec.Strategy.Canary.Steps, func(s v1alpha1.CanaryStep) bool {
-		return s.SetHeaderRoute != nil
-	}) {
-		// none of the steps have a SetHeaderRoute so nothing to clean up
-		return pluginTypes.RpcError{}
+	// re-fetch the matched routetables to see what is actually in the cluster; a RouteTable which can't be read can't
+	// be verified either
+	matchedRts, err := r.getAllRouteTables(ctx, rollout, glooPluginConfig)
+	if err != nil {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
 	}
+	if len(matchedRts) == 0 {
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: noMatchingRoutesError(rollout, glooPluginConfig).Error(),
+		}
+	}
+
+	if r.isDryRun(glooPluginConfig) {
+		// nothing was written, so the weights would never match
+		r.logDryRunVerification(matchedRts, desiredWeight, additionalDestinations)
+		return pluginTypes.Verified, pluginTypes.RpcError{}
+	}
+	return r.verifyWeight(matchedRts, desiredWeight, additionalDestinations)
+}
 
+func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.RpcError {
 	ctx := context.TODO()
 	glooPluginConfig, err := getPluginConfig(rollout)
 	if err != nil {
@@ -186,6 +717,20 @@ func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.R
 		}
 	}
 
+	var managedRoutes, mirrorRoutes []string
+	// only routes of SetHeaderRoute or SetMirrorRoute steps need to be cleaned up
+	if slices.ContainsFunc(rollout.Spec.Strategy.Canary.Steps, func(s v1alpha1.CanaryStep) bool {
+		return s.SetHeaderRoute != nil || s.SetMirrorRoute != nil
+	}) {
+		for _, managed := range rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes {
+			managedRoutes = append(managedRoutes, managed.Name)
+		}
+		for _, step := range rollout.Spec.Strategy.Canary.Steps {
+			if step.SetMirrorRoute != nil {
+				mirrorRoutes = append(mirrorRoutes, step.SetMirrorRoute.Name)
+			}
+		}
+	}
 	// get the matched routetables
 	matchedRts, err := r.getRouteTables(ctx, rollout, glooPluginConfig)
 	if err != nil {
@@ -198,101 +743,275 @@ func (r *RpcPlugin) RemoveManagedRoutes(rollout *v1alpha1.Rollout) pluginTypes.R
 		return pluginTypes.RpcError{}
 	}
 
-	var combinedError error
-	for _, rt := range matchedRts {
-		originalRouteTable := &networkv2.RouteTable{}
-		rt.RouteTable.DeepCopyInto(originalRouteTable)
-		newRoutes := slices.DeleteFunc(rt.RouteTable.Spec.Http, func(r *networkv2.HTTPRoute) bool {
-			for _, managed := range rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes {
-				if strings.EqualFold(r.GetName(), managed.Name) {
-					return true
-				}
-			}
-			return false
-		})
-
-		rt.RouteTable.Spec.Http = newRoutes
-		if r.IsTest {
-			r.LogCtx.Debugf("test route table http routes: %v", rt.RouteTable.Spec.Http)
-			continue
-		}
-		if e := patchRouteTable(ctx, r.Client, rt.RouteTable, originalRouteTable); e != nil {
-			combinedError = errors.Join(combinedError, e)
-			continue
-		}
-		r.LogCtx.Debugf("patched route table %s.%s", rt.RouteTable.Namespace, rt.RouteTable.Name)
-
+	// the rollout is done, so the canary destinations left without traffic go together with the managed routes
+	err = r.updateRouteTables(ctx, matchedRts, func(rt *GlooMatchedRouteTable) error {
+		deleteNamedRoutes(rt, managedRoutes)
+		removeCanaryDestinations(rt)
+		return nil
+	})
+	err = errors.Join(err, r.removeMirrorPolicies(ctx, matchedRts, mirrorRoutes, r.isDryRun(glooPluginConfig)))
+	if err == nil {
+		// the canary VirtualDestinations can only go once no route forwards to them
+		err = r.removeCanaryVirtualDestinations(ctx, glooPluginConfig, matchedRts)
 	}
-
-	if combinedError != nil {
+	if err != nil {
 		return pluginTypes.RpcError{
-			ErrorString: combinedError.Error(),
+			ErrorString: err.Error(),
 		}
 	}
 
 	return pluginTypes.RpcError{}
 }
 
+// removeRoutes deletes the named http routes from the matched route tables. The weighted routes are left alone, as
+// the rollout may still be splitting traffic.
+func (r *RpcPlugin) removeRoutes(ctx context.Context, matchedRts []*GlooMatchedRouteTable, routeNames []string) error {
+	return r.updateRouteTables(ctx, matchedRts, func(rt *GlooMatchedRouteTable) error {
+		deleteNamedRoutes(rt, routeNames)
+		return nil
+	})
+}
+
+// deleteNamedRoutes deletes the named http routes from the route table
+func deleteNamedRoutes(rt *GlooMatchedRouteTable, routeNames []string) {
+	rt.RouteTable.Spec.Http = slices.DeleteFunc(rt.RouteTable.Spec.Http, func(r *networkv2.HTTPRoute) bool {
+		return slices.ContainsFunc(routeNames, func(name string) bool { return isNamedRoute(r, name) })
+	})
+}
+
 func (r *RpcPlugin) Type() string {
 	return Type
 }
 
+// getRouteTables matches the routes of the RouteTables selected by every target. RouteTables which error are left out
+// and reported, unless the updates are transactional: updating only some RouteTables isn't all-or-nothing.
 func (r *RpcPlugin) getRouteTables(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) ([]*GlooMatchedRouteTable, error) {
-	if glooPluginConfig.RouteTableSelector == nil {
+	return r.matchRouteTables(ctx, rollout, glooPluginConfig, glooPluginConfig.Transactional)
+}
+
+// getAllRouteTables matches the routes of the RouteTables selected by every target, and fails if any of them errors
+func (r *RpcPlugin) getAllRouteTables(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) ([]*GlooMatchedRouteTable, error) {
+	return r.matchRouteTables(ctx, rollout, glooPluginConfig, true)
+}
+
+func (r *RpcPlugin) matchRouteTables(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting, requireAll bool) ([]*GlooMatchedRouteTable, error) {
+	targets := glooPluginConfig.targets()
+	if len(targets) == 0 {
 		return nil, fmt.Errorf("routeTable selector is required")
 	}
 
-	if strings.EqualFold(glooPluginConfig.RouteTableSelector.Namespace, "") {
-		r.LogCtx.Debugf("defaulting routeTableSelector namespace to Rollout namespace %s for rollout %s", rollout.Namespace, rollout.Name)
-		glooPluginConfig.RouteTableSelector.Namespace = rollout.Namespace
-	}
+	// a RouteTable matched by several targets is matched once, so that all changes end up in the same object
+	matchedByKey := map[string]*GlooMatchedRouteTable{}
+	matched := []*GlooMatchedRouteTable{}
+	summary := &routeTableMatchSummary{}
 
-	var rts []*networkv2.RouteTable
+	for i, target := range targets {
+		if target.RouteTableSelector == nil {
+			return nil, fmt.Errorf("routeTable selector is required for every target")
+		}
+		targetConfig := *glooPluginConfig
+		targetConfig.RouteTableSelector = target.RouteTableSelector
+		targetConfig.RouteSelector = target.RouteSelector
 
-	if !strings.EqualFold(glooPluginConfig.RouteTableSelector.Name, "") {
-		r.LogCtx.Debugf("getRouteTables using ns:name ref %s:%s to get single table", glooPluginConfig.RouteTableSelector.Name, glooPluginConfig.RouteTableSelector.Namespace)
-		result, err := r.Client.RouteTables().GetRouteTable(ctx, glooPluginConfig.RouteTableSelector.Name, glooPluginConfig.RouteTableSelector.Namespace)
+		rts, err := r.selectRouteTables(ctx, rollout, &targetConfig)
 		if err != nil {
-			return nil, err
+			summary.add(fmt.Sprintf("target %d", i), routeTableErrored, err.Error())
+			continue
 		}
 
-		r.LogCtx.Debugf("getRouteTables using ns:name ref %s:%s found 1 table", glooPluginConfig.RouteTableSelector.Name, glooPluginConfig.RouteTableSelector.Namespace)
-		rts = append(rts, result)
-	} else {
-		opts := &k8sclient.ListOptions{}
+		for _, rt := range rts {
+			previous, shared := matchedByKey[routeTableKey(rt)]
+			if shared {
+				rt = previous.RouteTable
+			}
+			matchedRt := &GlooMatchedRouteTable{
+				RouteTable:       rt,
+				weightPercentage: target.WeightPercentage,
+				rollout:          rollout,
+				targetConfigs:    []*GlooPlatformAPITrafficRouting{&targetConfig},
+			}
+			// destination matching
+			if err := matchedRt.matchRoutes(r.LogCtx, rollout, &targetConfig); err != nil {
+				summary.add(routeTableKey(rt), routeTableErrored, err.Error())
+				continue
+			}
+			routeCount := len(matchedRt.HttpRoutes) + len(matchedRt.TCPRoutes) + len(matchedRt.TLSRoutes)
+			if routeCount == 0 {
+				summary.add(routeTableKey(rt), routeTableSkipped, "no selected route forwards to the stable or active destination")
+				continue
+			}
 
-		if glooPluginConfig.RouteTableSelector.Labels != nil {
-			opts.LabelSelector = labels.SelectorFromSet(glooPluginConfig.RouteTableSelector.Labels)
+			if shared {
+				if !equalWeightPercentages(previous.weightPercentage, matchedRt.weightPercentage) {
+					return nil, fmt.Errorf("RouteTable %s.%s is matched by targets with different weight percentages", rt.Namespace, rt.Name)
+				}
+				previous.merge(matchedRt)
+			} else {
+				matchedByKey[routeTableKey(rt)] = matchedRt
+				matched = append(matched, matchedRt)
+			}
+			summary.add(routeTableKey(rt), routeTableMatched, fmt.Sprintf("%d route(s)", routeCount))
 		}
-		if !strings.EqualFold(glooPluginConfig.RouteTableSelector.Namespace, "") {
-			opts.Namespace = glooPluginConfig.RouteTableSelector.Namespace
+	}
+
+	summary.log(r.LogCtx)
+	if len(matched) == 0 && summary.hasErrors() {
+		return nil, fmt.Errorf("no usable RouteTables found: %s", summary)
+	}
+	if requireAll && summary.hasErrors() {
+		return nil, fmt.Errorf("not all RouteTables are usable: %s", summary)
+	}
+
+	return matched, nil
+}
+
+const (
+	routeTableMatched = "matched"
+	routeTableSkipped = "skipped"
+	routeTableErrored = "errored"
+)
+
+// routeTableMatchSummary records the outcome of matching the routes of every selected RouteTable
+type routeTableMatchSummary struct {
+	results []routeTableMatchResult
+}
+
+type routeTableMatchResult struct {
+	// namespace.name of the RouteTable, or the target whose RouteTables couldn't be selected
+	routeTable string
+	result     string
+	reason     string
+}
+
+func (s *routeTableMatchSummary) add(routeTable, result, reason string) {
+	s.results = append(s.results, routeTableMatchResult{routeTable: routeTable, result: result, reason: reason})
+}
+
+func (s *routeTableMatchSummary) hasErrors() bool {
+	return slices.ContainsFunc(s.results, func(r routeTableMatchResult) bool {
+		return r.result == routeTableErrored
+	})
+}
+
+func (s *routeTableMatchSummary) log(logCtx *logrus.Entry) {
+	for _, r := range s.results {
+		entry := logCtx.WithFields(logrus.Fields{"routeTable": r.routeTable, "result": r.result, "reason": r.reason})
+		if r.result == routeTableErrored {
+			entry.Warn("failed to match routes of RouteTable")
+			continue
 		}
+		entry.Debug("matched routes of RouteTable")
+	}
+}
 
-		r.LogCtx.Debugf("getRouteTables listing tables with opts %+v", opts)
-		var err error
+func (s *routeTableMatchSummary) String() string {
+	if len(s.results) == 0 {
+		return "no RouteTables selected"
+	}
+	var results []string
+	for _, r := range s.results {
+		results = append(results, fmt.Sprintf("%s %s: %s", r.routeTable, r.result, r.reason))
+	}
+	return strings.Join(results, "; ")
+}
 
-		rts, err = r.Client.RouteTables().ListRouteTable(ctx, opts)
-		if err != nil {
-			return nil, err
+func equalWeightPercentages(a, b *int32) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// noMatchingRoutesError describes the selection which found no routes to change
+func noMatchingRoutesError(rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) error {
+	stable := fmt.Sprintf("service %s", getStableOrActiveService(rollout))
+	if glooPluginConfig.StableDestinationMatcher != nil {
+		stable = "the stableDestinationMatcher"
+	}
+	canary := fmt.Sprintf("service %s", getCanaryOrPreviewService(rollout))
+	if glooPluginConfig.CanaryDestinationMatcher != nil {
+		canary = "the canaryDestinationMatcher"
+	}
+
+	var targets []string
+	for _, target := range glooPluginConfig.targets() {
+		routeSelector := "all routes"
+		if target.RouteSelector != nil {
+			routeSelector = target.RouteSelector.String()
 		}
-		r.LogCtx.Debugf("getRouteTables listing tables with opts %+v; found %d routeTables", opts, len(rts))
+		targets = append(targets, fmt.Sprintf("routeTableSelector (%s) with routeSelector (%s)", target.RouteTableSelector, routeSelector))
 	}
 
-	matched := []*GlooMatchedRouteTable{}
+	return fmt.Errorf("no routes of rollout %s.%s forwarding to %s, with canary %s, found in the RouteTables selected by %s", rollout.Namespace, rollout.Name, stable, canary, strings.Join(targets, "; "))
+}
+
+// selectRouteTables returns the RouteTables selected by the RouteTable selector of the config
+func (r *RpcPlugin) selectRouteTables(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) ([]*networkv2.RouteTable, error) {
+	selector := glooPluginConfig.RouteTableSelector
+	if strings.EqualFold(selector.Namespace, "") && len(selector.Namespaces) == 0 && !selector.AllNamespaces {
+		r.LogCtx.Debugf("defaulting routeTableSelector namespace to Rollout namespace %s for rollout %s", rollout.Namespace, rollout.Name)
+		selector.Namespace = rollout.Namespace
+	}
 
-	for _, rt := range rts {
-		matchedRt := &GlooMatchedRouteTable{
-			RouteTable: rt,
+	var listed []*networkv2.RouteTable
+
+	for _, namespace := range selector.namespaces() {
+		if !strings.EqualFold(selector.Name, "") && namespace != metav1.NamespaceAll {
+			r.LogCtx.Debugf("getRouteTables using ns:name ref %s:%s to get single table", namespace, selector.Name)
+			result, err := r.Client.RouteTables().GetRouteTable(ctx, selector.Name, namespace)
+			if err != nil {
+				// the named table only needs to exist in one of several namespaces
+				if k8serrors.IsNotFound(err) && len(selector.namespaces()) > 1 {
+					r.LogCtx.Debugf("getRouteTables found no table %s:%s", namespace, selector.Name)
+					continue
+				}
+				return nil, err
+			}
+
+			r.LogCtx.Debugf("getRouteTables using ns:name ref %s:%s found 1 table", namespace, selector.Name)
+			listed = append(listed, result)
+			continue
+		}
+
+		opts := &k8sclient.ListOptions{
+			Namespace: namespace,
 		}
-		// destination matching
-		if err := matchedRt.matchRoutes(r.LogCtx, rollout, glooPluginConfig); err != nil {
-			return nil, err // TODO: don't short circuit, potentially other RTs will match if we continue instead of immediately returning an error
+		if len(selector.Labels) > 0 || len(selector.MatchExpressions) > 0 {
+			labelSelector, err := labelSelector(selector.Labels, selector.MatchExpressions)
+			if err != nil {
+				return nil, fmt.Errorf("routeTableSelector: %s", err)
+			}
+			opts.LabelSelector = labelSelector
 		}
 
-		matched = append(matched, matchedRt)
+		r.LogCtx.Debugf("getRouteTables listing tables with opts %+v", opts)
+		result, err := r.Client.RouteTables().ListRouteTable(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.LogCtx.Debugf("getRouteTables listing tables with opts %+v; found %d routeTables", opts, len(result))
+		for _, rt := range result {
+			// tables of all namespaces are listed to find the named ones
+			if !strings.EqualFold(selector.Name, "") && !strings.EqualFold(selector.Name, rt.Name) {
+				continue
+			}
+			listed = append(listed, rt)
+		}
 	}
 
-	return matched, nil
+	var rts []*networkv2.RouteTable
+	for _, rt := range listed {
+		if !selector.selectsRouteTable(rt) {
+			r.LogCtx.Debugf("getRouteTables skipping table %s:%s because it doesn't serve the selected hosts or virtual gateways", rt.Namespace, rt.Name)
+			continue
+		}
+		rts = append(rts, rt)
+	}
+
+	if glooPluginConfig.FollowDelegation {
+		return r.followDelegation(ctx, rts)
+	}
+	return rts, nil
 }
 
 func (g *GlooMatchedRouteTable) matchRoutes(logCtx *logrus.Entry, rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) error {
@@ -300,7 +1019,22 @@ func (g *GlooMatchedRouteTable) matchRoutes(logCtx *logrus.Entry, rollout *v1alp
 		return fmt.Errorf("matchRoutes called for nil RouteTable")
 	}
 
+	if strings.EqualFold(getStableOrActiveService(rollout), "") {
+		return fmt.Errorf("rollout %s.%s has no stable or active service", rollout.Namespace, rollout.Name)
+	}
+	g.scope = newDestinationScope(rollout, trafficConfig)
+
+	routeLabelSelector := labels.Everything()
+	if trafficConfig.RouteSelector != nil && trafficConfig.RouteSelector.selectsByLabels() {
+		selector, err := labelSelector(trafficConfig.RouteSelector.Labels, trafficConfig.RouteSelector.MatchExpressions)
+		if err != nil {
+			return fmt.Errorf("routeSelector: %s", err)
+		}
+		routeLabelSelector = selector
+	}
+
 	// HTTP Routes
+	managedRoutes := managedRouteNames(rollout, trafficConfig)
 	for _, httpRoute := range g.RouteTable.Spec.Http {
 		// find the destination that matches the stable svc
 		fw := httpRoute.GetForwardTo()
@@ -309,58 +1043,34 @@ func (g *GlooMatchedRouteTable) matchRoutes(logCtx *logrus.Entry, rollout *v1alp
 			continue
 		}
 
+		// header and mirror routes the plugin created forward to the stable destination too, but are no weighted routes
+		if isManagedRoute(httpRoute, managedRoutes) {
+			logCtx.Debugf("skipping route %s.%s because it is managed by the plugin", g.RouteTable.Name, httpRoute.Name)
+			continue
+		}
+
 		// skip non-matching routes if RouteSelector provided
 		if trafficConfig.RouteSelector != nil {
+			// http routes have no SNI hosts
+			if len(trafficConfig.RouteSelector.SniHosts) > 0 {
+				logCtx.Debugf("skipping route %s.%s because it has no SNI hosts for the RouteSelector", g.RouteTable.Name, httpRoute.Name)
+				continue
+			}
 			// if name was provided, skip if route name doesn't match
 			if !strings.EqualFold(trafficConfig.RouteSelector.Name, "") && !strings.EqualFold(trafficConfig.RouteSelector.Name, httpRoute.Name) {
 				logCtx.Debugf("skipping route %s.%s because it doesn't match route name selector %s", g.RouteTable.Name, httpRoute.Name, trafficConfig.RouteSelector.Name)
 				continue
 			}
-			// if labels provided, skip if route labels do not contain all specified labels
-			if trafficConfig.RouteSelector.Labels != nil {
-				matchedLabels := func() bool {
-					for k, v := range trafficConfig.RouteSelector.Labels {
-						if vv, ok := httpRoute.Labels[k]; ok {
-							if !strings.EqualFold(v, vv) {
-								logCtx.Debugf("skipping route %s.%s because route labels do not contain %s=%s", g.RouteTable.Name, httpRoute.Name, k, v)
-								return false
-							}
-						}
-					}
-					return true
-				}()
-				if !matchedLabels {
-					continue
-				}
+			// skip if route labels don't match the label selector
+			if !routeLabelSelector.Matches(labels.Set(httpRoute.Labels)) {
+				logCtx.Debugf("skipping route %s.%s because route labels don't match label selector %s", g.RouteTable.Name, httpRoute.Name, routeLabelSelector)
+				continue
 			}
 			logCtx.Debugf("route %s.%s passed RouteSelector", g.RouteTable.Name, httpRoute.Name)
 		}
 
 		// find destinations
-		// var matchedDestinations []*GlooDestinations
-		var canary, stable *solov2.DestinationReference
-		for _, dest := range fw.Destinations {
-			ref := dest.GetRef()
-			if ref == nil {
-				logCtx.Debugf("skipping destination %s.%s because destination ref was nil; %+v", g.RouteTable.Name, httpRoute.Name, dest)
-				continue
-			}
-			if strings.EqualFold(ref.Name, rollout.Spec.Strategy.Canary.StableService) {
-				logCtx.Debugf("matched stable ref %s.%s.%s", g.RouteTable.Name, httpRoute.Name, ref.Name)
-				stable = dest
-				continue
-			}
-			if strings.EqualFold(ref.Name, rollout.Spec.Strategy.Canary.CanaryService) {
-				logCtx.Debugf("matched canary ref %s.%s.%s", g.RouteTable.Name, httpRoute.Name, ref.Name)
-				canary = dest
-				// bail if we found both stable and canary
-				if stable != nil {
-					break
-				}
-				continue
-			}
-		}
-
+		stable, canary := g.matchDestinations(logCtx, fmt.Sprintf("%s.%s", g.RouteTable.Name, httpRoute.Name), fw.Destinations, rollout, trafficConfig)
 		if stable != nil {
 			dest := &GlooMatchedHttpRoutes{
 				HttpRoute: httpRoute,
@@ -373,10 +1083,176 @@ func (g *GlooMatchedRouteTable) matchRoutes(logCtx *logrus.Entry, rollout *v1alp
 		}
 	} // end range httpRoutes
 
+	// TCP Routes
+	for i, tcpRoute := range g.RouteTable.Spec.Tcp {
+		routeName := fmt.Sprintf("%s.tcp[%d]", g.RouteTable.Name, i)
+		fw := tcpRoute.GetForwardTo()
+		if fw == nil {
+			logCtx.Debugf("skipping route %s because forwardTo is nil", routeName)
+			continue
+		}
+
+		// tcp routes have neither names, labels nor SNI hosts to select them by
+		if trafficConfig.RouteSelector != nil && (trafficConfig.RouteSelector.Name != "" || trafficConfig.RouteSelector.selectsByLabels() || len(trafficConfig.RouteSelector.SniHosts) > 0) {
+			logCtx.Debugf("skipping route %s because it has no name, labels or SNI hosts for the RouteSelector", routeName)
+			continue
+		}
+
+		stable, canary := g.matchDestinations(logCtx, routeName, fw.Destinations, rollout, trafficConfig)
+		if stable != nil {
+			g.TCPRoutes = append(g.TCPRoutes, &GlooMatchedTCPRoutes{
+				TCPRoute: tcpRoute,
+				Destinations: []*GlooDestinations{{
+					StableOrActiveDestination:  stable,
+					CanaryOrPreviewDestination: canary,
+				}},
+			})
+		}
+	} // end range tcpRoutes
+
+	// TLS Routes
+	for i, tlsRoute := range g.RouteTable.Spec.Tls {
+		routeName := fmt.Sprintf("%s.tls[%d]", g.RouteTable.Name, i)
+		fw := tlsRoute.GetForwardTo()
+		if fw == nil {
+			logCtx.Debugf("skipping route %s because forwardTo is nil", routeName)
+			continue
+		}
+
+		if trafficConfig.RouteSelector != nil {
+			// tls routes have neither names nor labels to select them by
+			if trafficConfig.RouteSelector.Name != "" || trafficConfig.RouteSelector.selectsByLabels() {
+				logCtx.Debugf("skipping route %s because it has no name or labels for the RouteSelector", routeName)
+				continue
+			}
+			if len(trafficConfig.RouteSelector.SniHosts) > 0 && !slices.ContainsFunc(tlsRoute.GetMatchers(), func(m *solov2.TLSRequestMatcher) bool {
+				return slices.ContainsFunc(m.GetSniHosts(), func(host string) bool {
+					return slices.ContainsFunc(trafficConfig.RouteSelector.SniHosts, func(selected string) bool {
+						return strings.EqualFold(host, selected)
+					})
+				})
+			}) {
+				logCtx.Debugf("skipping route %s because it doesn't match SNI hosts selector %v", routeName, trafficConfig.RouteSelector.SniHosts)
+				continue
+			}
+			logCtx.Debugf("route %s passed RouteSelector", routeName)
+		}
+
+		stable, canary := g.matchDestinations(logCtx, routeName, fw.Destinations, rollout, trafficConfig)
+		if stable != nil {
+			g.TLSRoutes = append(g.TLSRoutes, &GlooMatchedTLSRoutes{
+				TLSRoute: tlsRoute,
+				Destinations: []*GlooDestinations{{
+					StableOrActiveDestination:  stable,
+					CanaryOrPreviewDestination: canary,
+				}},
+			})
+		}
+	} // end range tlsRoutes
+
 	return nil
 }
 
-func buildGlooMatches(headerRouting *v1alpha1.SetHeaderRoute) *solov2.HTTPRequestMatcher {
+// managedRouteNames returns the names of the http routes the plugin creates for the rollout: the managed routes and
+// the routes of the setHeaderRoute and setMirrorRoute steps
+func managedRouteNames(rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) []string {
+	var names []string
+	if canary := rollout.Spec.Strategy.Canary; canary != nil {
+		if canary.TrafficRouting != nil {
+			for _, managed := range canary.TrafficRouting.ManagedRoutes {
+				names = append(names, managed.Name)
+			}
+		}
+		for _, step := range canary.Steps {
+			if step.SetHeaderRoute != nil {
+				names = append(names, step.SetHeaderRoute.Name)
+			}
+			if step.SetMirrorRoute != nil {
+				names = append(names, step.SetMirrorRoute.Name)
+			}
+		}
+	}
+	return names
+}
+
+// isManagedRoute reports whether the http route is a mirror route or has the name of a route the plugin manages
+func isManagedRoute(route *networkv2.HTTPRoute, managedRoutes []string) bool {
+	if _, ok := route.GetLabels()[MirrorRouteLabel]; ok {
+		return true
+	}
+	return slices.ContainsFunc(managedRoutes, func(name string) bool {
+		return name != "" && strings.EqualFold(route.GetName(), name)
+	})
+}
+
+// isNamedRoute reports whether the http route has the name, or is one of the mirror routes of the SetMirrorRoute of the name
+func isNamedRoute(route *networkv2.HTTPRoute, name string) bool {
+	return strings.EqualFold(route.GetName(), name) || route.GetLabels()[MirrorRouteLabel] == name
+}
+
+// matchDestinations finds the stable and canary destinations among the destinations of a route
+func (g *GlooMatchedRouteTable) matchDestinations(logCtx *logrus.Entry, routeName string, destinations []*solov2.DestinationReference, rollout *v1alpha1.Rollout, trafficConfig *GlooPlatformAPITrafficRouting) (*solov2.DestinationReference, *solov2.DestinationReference) {
+	stableHash, canaryHash := getPodTemplateHashes(rollout)
+
+	var canary, stable *solov2.DestinationReference
+	for _, dest := range destinations {
+		ref := dest.GetRef()
+		if ref == nil {
+			logCtx.Debugf("skipping destination %s because destination ref was nil; %+v", routeName, dest)
+			continue
+		}
+		if trafficConfig.PodTemplateHashSubsets {
+			// stable and canary both reference the stable service and are told apart by their pod-template-hash subset;
+			// without a known canary hash the first destination is the stable one
+			if !g.isStableOrActive(dest, rollout, trafficConfig) {
+				continue
+			}
+			hash := dest.GetSubset()[v1alpha1.DefaultRolloutUniqueLabelKey]
+			if canary == nil && canaryHash != "" && canaryHash != stableHash && hash == canaryHash {
+				logCtx.Debugf("matched canary ref %s.%s with hash %s", routeName, ref.Name, hash)
+				canary = dest
+			} else if stable == nil {
+				logCtx.Debugf("matched stable ref %s.%s with hash %s", routeName, ref.Name, hash)
+				stable = dest
+			} else if canary == nil {
+				logCtx.Debugf("matched canary ref %s.%s with hash %s", routeName, ref.Name, hash)
+				canary = dest
+			}
+			continue
+		}
+		if g.isStableOrActive(dest, rollout, trafficConfig) {
+			logCtx.Debugf("matched stable ref %s.%s", routeName, ref.Name)
+			stable = dest
+			continue
+		}
+		if g.isCanaryOrPreview(dest, rollout, trafficConfig) {
+			logCtx.Debugf("matched canary ref %s.%s", routeName, ref.Name)
+			canary = dest
+			// bail if we found both stable and canary
+			if stable != nil {
+				break
+			}
+			continue
+		}
+	}
+	if canary == nil && stable != nil && managesCanaryVirtualDestination(stable, trafficConfig) {
+		for _, dest := range destinations {
+			if isCanaryVirtualDestinationOf(dest, stable, g.RouteTable.Namespace) {
+				logCtx.Debugf("matched canary virtual destination ref %s.%s", routeName, dest.GetRef().GetName())
+				canary = dest
+				break
+			}
+		}
+	}
+	return stable, canary
+}
+
+// buildGlooMatches returns a matcher requiring all headers of the header route. A header route without header matches
+// would match all requests of the routes it is cloned from, so it is rejected.
+func buildGlooMatches(headerRouting *v1alpha1.SetHeaderRoute) (*solov2.HTTPRequestMatcher, error) {
+	if len(headerRouting.Match) == 0 {
+		return nil, fmt.Errorf("header route %s has no header match", headerRouting.Name)
+	}
 	matcher := &solov2.HTTPRequestMatcher{
 		Name:    headerRouting.Name + "-matcher",
 		Headers: []*solov2.HeaderMatcher{},
@@ -385,14 +1261,19 @@ func buildGlooMatches(headerRouting *v1alpha1.SetHeaderRoute) *solov2.HTTPReques
 	for _, m := range headerRouting.Match {
 		var isRegex bool
 		var matchValue string
-		if m.HeaderValue.Exact != "" {
+		switch {
+		case m.HeaderName == "" || m.HeaderValue == nil:
+			return nil, fmt.Errorf("header route %s: every match needs a headerName and a headerValue", headerRouting.Name)
+		case m.HeaderValue.Exact != "":
 			matchValue = m.HeaderValue.Exact
-		} else if m.HeaderValue.Regex != "" {
+		case m.HeaderValue.Regex != "":
 			matchValue = m.HeaderValue.Regex
 			isRegex = true
-		} else if m.HeaderValue.Prefix != "" {
+		case m.HeaderValue.Prefix != "":
 			matchValue = "^" + regexp.QuoteMeta(m.HeaderValue.Prefix)
 			isRegex = true
+		default:
+			return nil, fmt.Errorf("header route %s: the match of header %s has no exact, regex or prefix value", headerRouting.Name, m.HeaderName)
 		}
 		headerMatcher := &solov2.HeaderMatcher{
 			Name:  m.HeaderName,
@@ -401,16 +1282,67 @@ func buildGlooMatches(headerRouting *v1alpha1.SetHeaderRoute) *solov2.HTTPReques
 		}
 		matcher.Headers = append(matcher.Headers, headerMatcher)
 	}
-	return matcher
+	return matcher, nil
 }
 
 func getPluginConfig(rollout *v1alpha1.Rollout) (*GlooPlatformAPITrafficRouting, error) {
 	glooplatformConfig := GlooPlatformAPITrafficRouting{}
 
-	err := json.Unmarshal(rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName], &glooplatformConfig)
+	// Argo Rollouts only calls traffic routers for the canary strategy; blueGreen rollouts switch their services instead
+	if rollout.Spec.Strategy.Canary == nil {
+		return nil, fmt.Errorf("rollout %s.%s has no canary strategy, the only strategy the %s plugin supports", rollout.Namespace, rollout.Name, PluginName)
+	}
+	if rollout.Spec.Strategy.Canary.TrafficRouting == nil {
+		return nil, fmt.Errorf("canary strategy of rollout %s.%s has no trafficRouting", rollout.Namespace, rollout.Name)
+	}
+	rawConfig := rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName]
+	if len(rawConfig) == 0 {
+		return nil, fmt.Errorf("no %s plugin config found for rollout %s.%s", PluginName, rollout.Namespace, rollout.Name)
+	}
+
+	err := json.Unmarshal(rawConfig, &glooplatformConfig)
 	if err != nil {
 		return nil, err
 	}
+	switch glooplatformConfig.WriteMode {
+	case "", WriteModePatch, WriteModeApply, WriteModeJSONPatch:
+	default:
+		return nil, fmt.Errorf("plugin config of rollout %s.%s: writeMode %q is not %q, %q or %q", rollout.Namespace, rollout.Name, glooplatformConfig.WriteMode, WriteModePatch, WriteModeApply, WriteModeJSONPatch)
+	}
+	for i, target := range glooplatformConfig.Targets {
+		if target.WeightPercentage != nil && (*target.WeightPercentage < 0 || *target.WeightPercentage > 100) {
+			return nil, fmt.Errorf("plugin config of rollout %s.%s: weightPercentage %d of target %d is not between 0 and 100", rollout.Namespace, rollout.Name, *target.WeightPercentage, i)
+		}
+	}
+	for _, matcher := range []*GlooDestinationMatcher{glooplatformConfig.StableDestinationMatcher, glooplatformConfig.CanaryDestinationMatcher} {
+		if matcher == nil {
+			continue
+		}
+		if err := matcher.validate(); err != nil {
+			return nil, fmt.Errorf("plugin config of rollout %s.%s: %s", rollout.Namespace, rollout.Name, err)
+		}
+	}
 
 	return &glooplatformConfig, nil
 }
+
+// getStableOrActiveService returns the canary stableService
+func getStableOrActiveService(rollout *v1alpha1.Rollout) string {
+	if rollout.Spec.Strategy.Canary != nil {
+		return rollout.Spec.Strategy.Canary.StableService
+	}
+	return ""
+}
+
+// getPodTemplateHashes returns the pod-template-hash of the stable and the current (canary) ReplicaSet
+func getPodTemplateHashes(rollout *v1alpha1.Rollout) (string, string) {
+	return rollout.Status.StableRS, rollout.Status.CurrentPodHash
+}
+
+// getCanaryOrPreviewService returns the canary canaryService
+func getCanaryOrPreviewService(rollout *v1alpha1.Rollout) string {
+	if rollout.Spec.Strategy.Canary != nil {
+		return rollout.Spec.Strategy.Canary.CanaryService
+	}
+	return ""
+}
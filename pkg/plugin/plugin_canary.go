@@ -2,144 +2,422 @@ package plugin
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 
-	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/gloo"
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
 	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
 	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func (r *RpcPlugin) handleCanary(ctx context.Context, rollout *v1alpha1.Rollout, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination, glooPluginConfig *GlooPlatformAPITrafficRouting, glooMatchedRouteTables []*GlooMatchedRouteTable) pluginTypes.RpcError {
 	remainingWeight := 100 - desiredWeight
+	for _, additional := range additionalDestinations {
+		remainingWeight -= additional.Weight
+	}
+	if remainingWeight < 0 {
+		return pluginTypes.RpcError{
+			ErrorString: fmt.Sprintf("desired weight %d and additional destination weights exceed 100", desiredWeight),
+		}
+	}
 
-	for _, rt := range glooMatchedRouteTables {
-		// the original rt is preserved to use for patch generation
-		ogRt := &networkv2.RouteTable{}
-		rt.RouteTable.DeepCopyInto(ogRt)
+	// once the rollout is done, the canary destinations the plugin created are taken out again
+	completed := desiredWeight == 0 && len(additionalDestinations) == 0 && rolloutCompleted(rollout)
+
+	setWeights := func(rt *GlooMatchedRouteTable) error {
+		addedAdditional, err := getAdditionalDestinations(rt.RouteTable, rollout)
+		if err != nil {
+			return err
+		}
+		previousAdditional := addedAdditional[rolloutKey(rollout)]
+		createdCanaries, err := getCanaryDestinations(rt.RouteTable)
+		if err != nil {
+			return err
+		}
+
+		canaryWeight := rt.scaleWeight(desiredWeight)
+		stableWeight := 100 - canaryWeight
+		for _, additional := range additionalDestinations {
+			stableWeight -= rt.scaleWeight(additional.Weight)
+		}
 
 		// set stable and canary (create canary destination if required)
-		for _, matchedHttpRoute := range rt.HttpRoutes {
-			if matchedHttpRoute.Destinations != nil {
-				matchedHttpRoute.Destinations.StableOrActiveDestination.Weight = uint32(remainingWeight)
-
-				if matchedHttpRoute.Destinations.CanaryOrPreviewDestination == nil {
-					newDest, err := r.newCanaryDest(matchedHttpRoute.Destinations.StableOrActiveDestination, rollout)
-					if err != nil {
-						return pluginTypes.RpcError{
-							ErrorString: err.Error(),
-						}
+		routes := rt.weightedRoutes()
+		for _, route := range routes {
+			authoredStableWeight := route.destinations.StableOrActiveDestination.GetWeight()
+			route.destinations.StableOrActiveDestination.Weight = uint32(stableWeight)
+
+			if route.destinations.CanaryOrPreviewDestination == nil && !completed {
+				newDest, err := r.newCanaryDest(rt, route.name, route.destinations.StableOrActiveDestination, rollout, glooPluginConfig)
+				if err != nil {
+					return err
+				}
+				route.destinations.CanaryOrPreviewDestination = newDest
+				*route.forwardTo = append(*route.forwardTo, route.destinations.CanaryOrPreviewDestination)
+				createdCanaries[route.name] = authoredStableWeight
+			}
+
+			if route.destinations.CanaryOrPreviewDestination != nil {
+				route.destinations.CanaryOrPreviewDestination.Weight = uint32(canaryWeight)
+			}
+
+			if err := r.setAdditionalDestinations(rt, route, rollout, glooPluginConfig, additionalDestinations, previousAdditional); err != nil {
+				return err
+			}
+		}
+		if len(routes) > 0 {
+			if err := setAdditionalDestinationsAnnotation(rt.RouteTable, rollout, addedAdditional, additionalDestinations); err != nil {
+				return err
+			}
+		}
+		if err := setCanaryDestinationsAnnotation(rt.RouteTable, createdCanaries); err != nil {
+			return err
+		}
+		if completed {
+			removeCanaryDestinations(rt)
+		}
+		return nil
+	}
+
+	// the canary VirtualDestinations are synced once before the RouteTables are written rather than on every retry of
+	// a write. Syncing them is idempotent, and they aren't rolled back when a write fails.
+	if !completed {
+		for _, rt := range glooMatchedRouteTables {
+			for _, route := range rt.weightedRoutes() {
+				if err := r.ensureCanaryVirtualDestination(ctx, rollout, rt, route.destinations.StableOrActiveDestination, glooPluginConfig); err != nil {
+					return pluginTypes.RpcError{
+						ErrorString: err.Error(),
 					}
-					matchedHttpRoute.Destinations.CanaryOrPreviewDestination = newDest
-					matchedHttpRoute.HttpRoute.GetForwardTo().Destinations = append(matchedHttpRoute.HttpRoute.GetForwardTo().Destinations, matchedHttpRoute.Destinations.CanaryOrPreviewDestination)
 				}
+			}
+		}
+	}
+
+	if err := r.updateRouteTables(ctx, glooMatchedRouteTables, setWeights); err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
 
-				matchedHttpRoute.Destinations.CanaryOrPreviewDestination.Weight = uint32(desiredWeight)
+	if completed {
+		if err := r.removeCanaryVirtualDestinations(ctx, glooPluginConfig, glooMatchedRouteTables); err != nil {
+			return pluginTypes.RpcError{
+				ErrorString: err.Error(),
 			}
 		}
+	}
+	return pluginTypes.RpcError{}
+}
+
+// newCanaryDest derives the canary or preview destination of a route from its stable or active destination
+func (r *RpcPlugin) newCanaryDest(rt *GlooMatchedRouteTable, routeName string, stable *solov2.DestinationReference, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) (*solov2.DestinationReference, error) {
+	newDest := typedCloneProto(stable)
+	if managesCanaryVirtualDestination(newDest, glooPluginConfig) {
+		newDest.GetRef().Name = canaryVirtualDestinationRef(newDest).GetName()
+		return newDest, nil
+	}
+	if !glooPluginConfig.PodTemplateHashSubsets {
+		matcher := glooPluginConfig.CanaryDestinationMatcher
+		if matcher == nil {
+			canaryService := getCanaryOrPreviewService(rollout)
+			if canaryService == "" {
+				return nil, fmt.Errorf("rollout %s.%s has no canary or preview service to derive the canary destination of route %s from", rollout.Namespace, rollout.Name, routeName)
+			}
+			newDest.GetRef().Name = canaryService
+			return newDest, nil
+		}
+
+		// only a matcher naming its destination tells what a missing canary destination references
+		if matcher.Ref.GetName() != "" {
+			newDest.GetRef().Name = matcher.Ref.GetName()
+			if matcher.Ref.GetNamespace() != "" {
+				newDest.GetRef().Namespace = matcher.Ref.GetNamespace()
+			}
+			if matcher.Ref.GetCluster() != "" {
+				newDest.GetRef().Cluster = matcher.Ref.GetCluster()
+			}
+			if matcher.Kind != "" {
+				newDest.Kind, _ = parseDestinationKind(matcher.Kind)
+			}
+		}
+		if matcher.Ref.GetName() == "" || !matcher.matches(newDest, rt.scope, rt.RouteTable.Namespace) {
+			return nil, fmt.Errorf("route %s of RouteTable %s.%s has no destination matching the canaryDestinationMatcher and none can be derived from it", routeName, rt.RouteTable.Namespace, rt.RouteTable.Name)
+		}
+		return newDest, nil
+	}
+
+	// same service, other pods; keep any other subset labels of the stable destination
+	_, canaryHash := getPodTemplateHashes(rollout)
+	if canaryHash == "" {
+		return nil, fmt.Errorf("rollout %s.%s has no current pod-template-hash to route the canary to", rollout.Namespace, rollout.Name)
+	}
+	if newDest.Subset == nil {
+		newDest.Subset = map[string]string{}
+	}
+	newDest.Subset[v1alpha1.DefaultRolloutUniqueLabelKey] = canaryHash
+	return newDest, nil
+}
+
+// setAdditionalDestinations weights the destinations of the additional services, deriving them from the stable
+// destination if required, and removes the additional destinations which are no longer wanted
+func (r *RpcPlugin) setAdditionalDestinations(rt *GlooMatchedRouteTable, route *glooWeightedRoute, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting, additionalDestinations []v1alpha1.WeightDestination, previousAdditional []string) error {
+	stable := route.destinations.StableOrActiveDestination
+	canary := route.destinations.CanaryOrPreviewDestination
+
+	*route.forwardTo = slices.DeleteFunc(*route.forwardTo, func(dest *solov2.DestinationReference) bool {
+		if dest == stable || dest == canary {
+			return false
+		}
+		return slices.ContainsFunc(previousAdditional, func(previous string) bool {
+			return rt.scope.refersTo(dest, previous, rt.RouteTable.Namespace)
+		}) && !slices.ContainsFunc(additionalDestinations, func(additional v1alpha1.WeightDestination) bool {
+			return rt.scope.refersTo(dest, additional.ServiceName, rt.RouteTable.Namespace)
+		})
+	})
+
+	for _, additional := range additionalDestinations {
+		dest := rt.findDestination(*route.forwardTo, additional.ServiceName)
+		if dest == nil {
+			dest = typedCloneProto(stable)
+			if dest.GetRef() == nil {
+				return fmt.Errorf("unable to derive destination for additional service %s from route %s", additional.ServiceName, route.name)
+			}
+			dest.GetRef().Name = additional.ServiceName
+			if glooPluginConfig.PodTemplateHashSubsets {
+				delete(dest.Subset, v1alpha1.DefaultRolloutUniqueLabelKey)
+				setPodTemplateHashSubset(dest, additional.PodTemplateHash)
+			}
+			*route.forwardTo = append(*route.forwardTo, dest)
+			r.LogCtx.Debugf("added additional destination %s to route %s of rollout %s.%s", additional.ServiceName, route.name, rollout.Namespace, rollout.Name)
+		}
+		dest.Weight = uint32(rt.scaleWeight(additional.Weight))
+	}
+	return nil
+}
+
+// getAdditionalDestinations returns the additional services the plugin added destinations for, by the namespace/name of
+// the rollout they were added for. Annotations listing the services without their rollout are taken to be the rollout's.
+func getAdditionalDestinations(rt *networkv2.RouteTable, rollout *v1alpha1.Rollout) (map[string][]string, error) {
+	added := map[string][]string{}
+	value := rt.GetAnnotations()[AdditionalDestinationsAnnotation]
+	if value == "" {
+		return added, nil
+	}
+	if !strings.HasPrefix(value, "{") {
+		added[rolloutKey(rollout)] = strings.Split(value, ",")
+		return added, nil
+	}
+	if err := json.Unmarshal([]byte(value), &added); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of RouteTable %s.%s: %s", AdditionalDestinationsAnnotation, rt.Namespace, rt.Name, err)
+	}
+	return added, nil
+}
 
-		// don't actually patch the RT
-		if r.IsTest {
+// setAdditionalDestinationsAnnotation records the additional services of the rollout, keeping those of other rollouts
+// sharing the RouteTable
+func setAdditionalDestinationsAnnotation(rt *networkv2.RouteTable, rollout *v1alpha1.Rollout, added map[string][]string, additionalDestinations []v1alpha1.WeightDestination) error {
+	delete(added, rolloutKey(rollout))
+	for _, additional := range additionalDestinations {
+		added[rolloutKey(rollout)] = append(added[rolloutKey(rollout)], additional.ServiceName)
+	}
+	if len(added) == 0 {
+		delete(rt.Annotations, AdditionalDestinationsAnnotation)
+		return nil
+	}
+	value, err := json.Marshal(added)
+	if err != nil {
+		return err
+	}
+	if rt.Annotations == nil {
+		rt.Annotations = map[string]string{}
+	}
+	rt.Annotations[AdditionalDestinationsAnnotation] = string(value)
+	return nil
+}
+
+// rolloutKey returns the namespace/name of the rollout
+func rolloutKey(rollout *v1alpha1.Rollout) string {
+	return fmt.Sprintf("%s/%s", rollout.Namespace, rollout.Name)
+}
+
+// rolloutCompleted reports whether the rollout was promoted or aborted, so that no canary traffic is expected anymore
+func rolloutCompleted(rollout *v1alpha1.Rollout) bool {
+	return rollout.Status.Abort || (rollout.Status.StableRS != "" && rollout.Status.StableRS == rollout.Status.CurrentPodHash)
+}
+
+// getCanaryDestinations returns the routes the plugin added a canary destination to, with their authored stable weight
+func getCanaryDestinations(rt *networkv2.RouteTable) (map[string]uint32, error) {
+	created := map[string]uint32{}
+	value := rt.GetAnnotations()[CanaryDestinationsAnnotation]
+	if value == "" {
+		return created, nil
+	}
+	if err := json.Unmarshal([]byte(value), &created); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation of RouteTable %s.%s: %s", CanaryDestinationsAnnotation, rt.Namespace, rt.Name, err)
+	}
+	return created, nil
+}
+
+func setCanaryDestinationsAnnotation(rt *networkv2.RouteTable, created map[string]uint32) error {
+	if len(created) == 0 {
+		delete(rt.Annotations, CanaryDestinationsAnnotation)
+		return nil
+	}
+	value, err := json.Marshal(created)
+	if err != nil {
+		return err
+	}
+	if rt.Annotations == nil {
+		rt.Annotations = map[string]string{}
+	}
+	rt.Annotations[CanaryDestinationsAnnotation] = string(value)
+	return nil
+}
+
+// removeCanaryDestinations takes the canary destinations the plugin created out of their routes again and restores the
+// authored stable weight, leaving the routes as they were before the first rollout. Canary destinations which still
+// get traffic are kept, as are the records of routes which aren't matched, so that they can be cleaned up later.
+func removeCanaryDestinations(rt *GlooMatchedRouteTable) {
+	created, err := getCanaryDestinations(rt.RouteTable)
+	if err != nil || len(created) == 0 {
+		return
+	}
+	for _, route := range rt.weightedRoutes() {
+		authoredStableWeight, ok := created[route.name]
+		if !ok {
 			continue
 		}
-		if err := r.Client.RouteTables().PatchRouteTable(ctx, rt.RouteTable, client.MergeFrom(ogRt)); err != nil {
-			return pluginTypes.RpcError{
-				ErrorString: fmt.Sprintf("failed to patch RouteTable: %s", err),
+		canary := route.destinations.CanaryOrPreviewDestination
+		if canary != nil {
+			if canary.GetWeight() != 0 {
+				continue
 			}
+			*route.forwardTo = slices.DeleteFunc(*route.forwardTo, func(dest *solov2.DestinationReference) bool {
+				return dest == canary
+			})
+			route.destinations.CanaryOrPreviewDestination = nil
 		}
-		r.LogCtx.Debugf("patched route table %s.%s", rt.RouteTable.Namespace, rt.RouteTable.Name)
+		route.destinations.StableOrActiveDestination.Weight = authoredStableWeight
+		delete(created, route.name)
+	}
+	_ = setCanaryDestinationsAnnotation(rt.RouteTable, created)
+}
 
+// handleUpdateHash writes the pod-template-hash subsets onto the matched stable and canary destinations
+func (r *RpcPlugin) handleUpdateHash(ctx context.Context, glooMatchedRouteTables []*GlooMatchedRouteTable, canaryHash, stableHash string) pluginTypes.RpcError {
+	err := r.updateRouteTables(ctx, glooMatchedRouteTables, func(rt *GlooMatchedRouteTable) error {
+		for _, route := range rt.weightedRoutes() {
+			setPodTemplateHashSubset(route.destinations.StableOrActiveDestination, stableHash)
+			setPodTemplateHashSubset(route.destinations.CanaryOrPreviewDestination, canaryHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
 	}
 
 	return pluginTypes.RpcError{}
 }
 
-func (r *RpcPlugin) newCanaryDest(stableDest *solov2.DestinationReference, rollout *v1alpha1.Rollout) (*solov2.DestinationReference, error) {
-	newDest := stableDest.Clone().(*solov2.DestinationReference)
-	newDest.GetRef().Name = rollout.Spec.Strategy.Canary.CanaryService
-	return newDest, nil
+func setPodTemplateHashSubset(dest *solov2.DestinationReference, hash string) {
+	if dest == nil || hash == "" {
+		return
+	}
+	if dest.Subset == nil {
+		dest.Subset = map[string]string{}
+	}
+	dest.Subset[v1alpha1.DefaultRolloutUniqueLabelKey] = hash
 }
 
 func typedCloneProto[T protoreflect.ProtoMessage](p T) T {
 	return proto.Clone(p).(T)
 }
 
-func (r *RpcPlugin) getOrDeriveCanary(mrt *GlooMatchedHttpRoutes, canaryService string) *networkv2.HTTPRoute_ForwardTo {
-	if mrt.Destinations.CanaryOrPreviewDestination != nil {
-		canary := typedCloneProto(mrt.Destinations.CanaryOrPreviewDestination)
-		canary.Weight = 0
-		return &networkv2.HTTPRoute_ForwardTo{
-			ForwardTo: &networkv2.ForwardToAction{
-				Destinations: []*solov2.DestinationReference{canary},
-			},
+// getOrDeriveCanary returns a forwardTo action to the canary or preview destination of the route, derived from its stable
+// or active destination the same way as for weighted routes if the route has none. A derived canary VirtualDestination
+// is synced by ensureDerivedCanaryVirtualDestinations beforehand.
+func (r *RpcPlugin) getOrDeriveCanary(ctx context.Context, rt *GlooMatchedRouteTable, mrt *GlooMatchedHttpRoutes, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting) (*networkv2.HTTPRoute_ForwardTo, error) {
+	canary := mrt.Destinations.CanaryOrPreviewDestination
+	if canary != nil {
+		canary = typedCloneProto(canary)
+	} else {
+		stable := mrt.Destinations.StableOrActiveDestination
+		if stable == nil {
+			return nil, nil // we don't have a canary and can't derive one
 		}
-	}
-	if mrt.Destinations.StableOrActiveDestination != nil {
-		newCanary := typedCloneProto(mrt.Destinations.StableOrActiveDestination)
-		newCanary.GetRef().Name = canaryService
-		newCanary.Weight = 0
-		return &networkv2.HTTPRoute_ForwardTo{
-			ForwardTo: &networkv2.ForwardToAction{
-				Destinations: []*solov2.DestinationReference{newCanary},
-			},
+		var err error
+		if canary, err = r.newCanaryDest(rt, mrt.HttpRoute.GetName(), stable, rollout, glooPluginConfig); err != nil {
+			return nil, err
 		}
 	}
-	return nil // we don't have a canary and can't derive one
+	canary.Weight = 0
+	return &networkv2.HTTPRoute_ForwardTo{
+		ForwardTo: &networkv2.ForwardToAction{
+			Destinations: []*solov2.DestinationReference{canary},
+		},
+	}, nil
 }
 
-func (r *RpcPlugin) handleHeaderRoute(ctx context.Context, routeTables []*GlooMatchedRouteTable, matcher *solov2.HTTPRequestMatcher, setHeaderRouteName string, canaryServiceName string) pluginTypes.RpcError {
-	var combinedError error
+// ensureDerivedCanaryVirtualDestinations syncs the canary VirtualDestinations of the http routes without a canary
+// destination, which getOrDeriveCanary derives one for
+func (r *RpcPlugin) ensureDerivedCanaryVirtualDestinations(ctx context.Context, rollout *v1alpha1.Rollout, routeTables []*GlooMatchedRouteTable, glooPluginConfig *GlooPlatformAPITrafficRouting) error {
 	for _, rt := range routeTables {
-		originalRouteTable := &networkv2.RouteTable{}
-		rt.RouteTable.DeepCopyInto(originalRouteTable)
+		for _, route := range rt.HttpRoutes {
+			if route.Destinations.CanaryOrPreviewDestination != nil {
+				continue
+			}
+			if err := r.ensureCanaryVirtualDestination(ctx, rollout, rt, route.Destinations.StableOrActiveDestination, glooPluginConfig); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
+func (r *RpcPlugin) handleHeaderRoute(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting, routeTables []*GlooMatchedRouteTable, matcher *solov2.HTTPRequestMatcher, setHeaderRouteName string) pluginTypes.RpcError {
+	setHeaderRoutes := func(rt *GlooMatchedRouteTable) error {
 		newHeaderRoutes := make([]*networkv2.HTTPRoute, 0)
 
 		for _, route := range rt.HttpRoutes {
-			canaryDestination := r.getOrDeriveCanary(route, canaryServiceName)
+			canaryDestination, err := r.getOrDeriveCanary(ctx, rt, route, rollout, glooPluginConfig)
+			if err != nil {
+				return err
+			}
 			setHeaderRoute := typedCloneProto(route.HttpRoute)
-			matcher := typedCloneProto(matcher)
 			setHeaderRoute.ActionType = canaryDestination
-			matchers := []*solov2.HTTPRequestMatcher{matcher}
-			setHeaderRoute.Matchers = append(matchers, setHeaderRoute.Matchers...)
+			// the matchers of a route are ORed, so the header match is added to each of them rather than next to them
+			setHeaderRoute.Matchers = mergeGlooMatchers(setHeaderRoute.Matchers, []*solov2.HTTPRequestMatcher{typedCloneProto(matcher)})
 			setHeaderRoute.Name = setHeaderRouteName
 
 			newHeaderRoutes = append(newHeaderRoutes, setHeaderRoute)
 
 		}
 
-		newHeaderRoutes = append(newHeaderRoutes, rt.RouteTable.Spec.Http...)
+		// replace a previously created route of the same name instead of stacking duplicates
+		newHeaderRoutes = append(newHeaderRoutes, slices.DeleteFunc(rt.RouteTable.Spec.Http, func(r *networkv2.HTTPRoute) bool {
+			return strings.EqualFold(r.GetName(), setHeaderRouteName)
+		})...)
 		rt.RouteTable.Spec.Http = newHeaderRoutes
+		return nil
+	}
 
-		if r.IsTest {
-			r.LogCtx.Debugf("test route table http routes: %v", rt.RouteTable.Spec.Http)
-			continue
-		}
-		if e := patchRouteTable(ctx, r.Client, rt.RouteTable, originalRouteTable); e != nil {
-			combinedError = errors.Join(combinedError, e)
-			continue
+	if err := r.ensureDerivedCanaryVirtualDestinations(ctx, rollout, routeTables, glooPluginConfig); err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
 		}
-		r.LogCtx.Debugf("patched route table %s.%s", rt.RouteTable.Namespace, rt.RouteTable.Name)
-
 	}
-
-	if combinedError != nil {
+	if err := r.updateRouteTables(ctx, routeTables, setHeaderRoutes); err != nil {
 		return pluginTypes.RpcError{
-			ErrorString: combinedError.Error(),
+			ErrorString: err.Error(),
 		}
 	}
 
 	return pluginTypes.RpcError{}
 }
-
-func patchRouteTable(ctx context.Context, glooClient gloo.NetworkV2ClientSet, new, old *networkv2.RouteTable) error {
-	if err := glooClient.RouteTables().PatchRouteTable(ctx, new, client.MergeFrom(old)); err != nil {
-		return fmt.Errorf("failed to patch RouteTable: %s", err)
-	}
-	return nil
-}
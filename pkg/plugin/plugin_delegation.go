@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// followDelegation adds the RouteTables the http routes of the RouteTables delegate to, recursively. Every RouteTable
+// is only visited once, so delegation cycles end the walk.
+func (r *RpcPlugin) followDelegation(ctx context.Context, rts []*networkv2.RouteTable) ([]*networkv2.RouteTable, error) {
+	visited := map[string]bool{}
+	for _, rt := range rts {
+		visited[routeTableKey(rt)] = true
+	}
+
+	result := rts
+	for i := 0; i < len(result); i++ {
+		parent := result[i]
+		for _, route := range parent.Spec.GetHttp() {
+			for _, selector := range route.GetDelegate().GetRouteTables() {
+				children, err := r.getDelegateRouteTables(ctx, selector)
+				if err != nil {
+					return nil, fmt.Errorf("failed to follow delegation of route %s in RouteTable %s.%s: %s", route.GetName(), parent.Namespace, parent.Name, err)
+				}
+				for _, child := range children {
+					if visited[routeTableKey(child)] {
+						r.LogCtx.Debugf("not following delegation from %s.%s to visited table %s.%s", parent.Namespace, parent.Name, child.Namespace, child.Name)
+						continue
+					}
+					visited[routeTableKey(child)] = true
+					r.LogCtx.Debugf("following delegation from %s.%s to table %s.%s", parent.Namespace, parent.Name, child.Namespace, child.Name)
+					result = append(result, child)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// getDelegateRouteTables returns the RouteTables selected by a delegate action. Gloo selects tables of all namespaces
+// if the selector has none; clusters and workspaces can't be told apart here, so they are not part of the selection.
+func (r *RpcPlugin) getDelegateRouteTables(ctx context.Context, selector *solov2.ObjectSelector) ([]*networkv2.RouteTable, error) {
+	if selector.GetName() != "" && selector.GetNamespace() != "" && len(selector.GetLabels()) == 0 {
+		rt, err := r.Client.RouteTables().GetRouteTable(ctx, selector.GetName(), selector.GetNamespace())
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []*networkv2.RouteTable{rt}, nil
+	}
+
+	opts := &k8sclient.ListOptions{
+		Namespace: selector.GetNamespace(),
+	}
+	if len(selector.GetLabels()) > 0 {
+		opts.LabelSelector = labels.SelectorFromSet(selector.GetLabels())
+	}
+	rts, err := r.Client.RouteTables().ListRouteTable(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var selected []*networkv2.RouteTable
+	for _, rt := range rts {
+		if selector.GetName() != "" && selector.GetName() != rt.Name {
+			continue
+		}
+		selected = append(selected, rt)
+	}
+	return selected, nil
+}
+
+func routeTableKey(rt *networkv2.RouteTable) string {
+	return fmt.Sprintf("%s.%s", rt.Namespace, rt.Name)
+}
@@ -0,0 +1,313 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// MirrorRouteLabel is set on mirror routes and their MirrorPolicies with the name of the SetMirrorRoute
+	MirrorRouteLabel = "glooplatform.argoproj.io/mirror-route"
+	// MirrorRouteTableLabel is set on mirror routes and their MirrorPolicies with the name of their RouteTable so
+	// MirrorPolicies don't select routes of other tables
+	MirrorRouteTableLabel = "glooplatform.argoproj.io/mirror-routetable"
+)
+
+// handleMirrorRoute adds a route per matched route which forwards matching requests to the stable destination and a
+// MirrorPolicy per RouteTable which mirrors the requests of those routes to the canary destination. The mirror routes
+// don't take part in the traffic split, so later weight changes don't have to be kept in sync with them.
+func (r *RpcPlugin) handleMirrorRoute(ctx context.Context, rollout *v1alpha1.Rollout, glooPluginConfig *GlooPlatformAPITrafficRouting, routeTables []*GlooMatchedRouteTable, setMirrorRoute *v1alpha1.SetMirrorRoute) pluginTypes.RpcError {
+	matchers, err := buildGlooMirrorMatches(setMirrorRoute)
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	percentage := float64(100)
+	if setMirrorRoute.Percentage != nil {
+		percentage = float64(*setMirrorRoute.Percentage)
+	}
+
+	if err := r.ensureDerivedCanaryVirtualDestinations(ctx, rollout, routeTables, glooPluginConfig); err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	// the MirrorPolicies are only written once the mirror routes of all route tables are
+	mirrorDestinations := map[*GlooMatchedRouteTable]*solov2.DestinationReference{}
+	err = r.updateRouteTables(ctx, routeTables, func(rt *GlooMatchedRouteTable) error {
+		newMirrorRoutes := make([]*networkv2.HTTPRoute, 0)
+		var mirrorDestination *solov2.DestinationReference
+		delete(mirrorDestinations, rt)
+
+		for _, route := range rt.HttpRoutes {
+			if mirrorDestination == nil {
+				canary, err := r.getOrDeriveCanary(ctx, rt, route, rollout, glooPluginConfig)
+				if err != nil {
+					return err
+				}
+				if canary != nil {
+					mirrorDestination = canary.ForwardTo.Destinations[0]
+				}
+			}
+
+			mirrorRoute := typedCloneProto(route.HttpRoute)
+			mirrorRoute.Name = setMirrorRoute.Name
+			if len(rt.HttpRoutes) > 1 {
+				// one mirror route per source route, so that their names don't clash
+				mirrorRoute.Name = fmt.Sprintf("%s-%s", setMirrorRoute.Name, httpRouteName(route.HttpRoute))
+			}
+			forwardTo := typedCloneProto(route.Destinations.StableOrActiveDestination)
+			forwardTo.Weight = 0
+			mirrorRoute.GetForwardTo().Destinations = []*solov2.DestinationReference{forwardTo}
+			mirrorRoute.Matchers = mergeGlooMatchers(mirrorRoute.Matchers, matchers)
+			if mirrorRoute.Labels == nil {
+				mirrorRoute.Labels = map[string]string{}
+			}
+			mirrorRoute.Labels[MirrorRouteLabel] = setMirrorRoute.Name
+			mirrorRoute.Labels[MirrorRouteTableLabel] = rt.RouteTable.Name
+
+			newMirrorRoutes = append(newMirrorRoutes, mirrorRoute)
+		}
+
+		// leave the route table alone when there is nothing to mirror to
+		if mirrorDestination == nil {
+			return nil
+		}
+		mirrorDestinations[rt] = mirrorDestination
+
+		// replace previously created routes of the same mirror route instead of stacking duplicates
+		newMirrorRoutes = append(newMirrorRoutes, slices.DeleteFunc(rt.RouteTable.Spec.Http, func(r *networkv2.HTTPRoute) bool {
+			return isNamedRoute(r, setMirrorRoute.Name)
+		})...)
+		rt.RouteTable.Spec.Http = newMirrorRoutes
+		return nil
+	})
+	if err != nil {
+		return pluginTypes.RpcError{
+			ErrorString: err.Error(),
+		}
+	}
+
+	var combinedError error
+	for _, rt := range routeTables {
+		mirrorDestination, ok := mirrorDestinations[rt]
+		if !ok {
+			r.LogCtx.Debugf("no canary destination for mirror route %s in route table %s.%s", setMirrorRoute.Name, rt.RouteTable.Namespace, rt.RouteTable.Name)
+			continue
+		}
+		if r.IsTest {
+			continue
+		}
+		if r.isDryRun(rt.config()) {
+			r.LogCtx.Infof("dry run: not creating or updating mirror policy %s.%s", rt.RouteTable.Namespace, mirrorPolicyName(rt.RouteTable, setMirrorRoute.Name))
+			continue
+		}
+
+		if e := r.upsertMirrorPolicy(ctx, rt.RouteTable, setMirrorRoute.Name, mirrorDestination, percentage); e != nil {
+			combinedError = errors.Join(combinedError, e)
+		}
+	}
+
+	if combinedError != nil {
+		return pluginTypes.RpcError{
+			ErrorString: combinedError.Error(),
+		}
+	}
+
+	return pluginTypes.RpcError{}
+}
+
+func mirrorPolicyName(rt *networkv2.RouteTable, mirrorRouteName string) string {
+	return fmt.Sprintf("%s-%s", rt.Name, mirrorRouteName)
+}
+
+func (r *RpcPlugin) upsertMirrorPolicy(ctx context.Context, rt *networkv2.RouteTable, mirrorRouteName string, destination *solov2.DestinationReference, percentage float64) error {
+	applyToRoutes := []*solov2.RouteSelector{
+		{
+			SelectorType: &solov2.RouteSelector_Route{
+				Route: &solov2.RouteLabelSelector{
+					Labels: map[string]string{
+						MirrorRouteLabel:      mirrorRouteName,
+						MirrorRouteTableLabel: rt.Name,
+					},
+					Namespace: rt.Namespace,
+				},
+			},
+		},
+	}
+	config := &trafficv2.MirrorPolicySpec_Config{
+		Destination: destination,
+		Percentage:  wrapperspb.Double(percentage),
+	}
+
+	name := mirrorPolicyName(rt, mirrorRouteName)
+	existing, err := r.Client.MirrorPolicies().GetMirrorPolicy(ctx, name, rt.Namespace)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get MirrorPolicy: %s", err)
+		}
+		mp := &trafficv2.MirrorPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: rt.Namespace,
+				Labels: map[string]string{
+					MirrorRouteLabel:      mirrorRouteName,
+					MirrorRouteTableLabel: rt.Name,
+				},
+			},
+			Spec: trafficv2.MirrorPolicySpec{
+				ApplyToRoutes: applyToRoutes,
+				Config:        config,
+			},
+		}
+		if err := r.Client.MirrorPolicies().CreateMirrorPolicy(ctx, mp); err != nil {
+			return fmt.Errorf("failed to create MirrorPolicy: %s", err)
+		}
+		r.LogCtx.Debugf("created mirror policy %s.%s", mp.Namespace, mp.Name)
+		return nil
+	}
+
+	original := &trafficv2.MirrorPolicy{}
+	existing.DeepCopyInto(original)
+	existing.Spec.ApplyToRoutes = applyToRoutes
+	existing.Spec.Config = config
+	if err := r.Client.MirrorPolicies().PatchMirrorPolicy(ctx, existing, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("failed to patch MirrorPolicy: %s", err)
+	}
+	r.LogCtx.Debugf("patched mirror policy %s.%s", existing.Namespace, existing.Name)
+	return nil
+}
+
+// removeMirrorPolicies deletes the MirrorPolicies created for the named mirror routes of the matched route tables, or
+// only logs them in a dry run. Mirror routes of the same name in other route tables keep their MirrorPolicies.
+func (r *RpcPlugin) removeMirrorPolicies(ctx context.Context, matchedRts []*GlooMatchedRouteTable, mirrorRouteNames []string, dryRun bool) error {
+	var combinedError error
+	for _, rt := range matchedRts {
+		for _, name := range mirrorRouteNames {
+			mp, err := r.Client.MirrorPolicies().GetMirrorPolicy(ctx, mirrorPolicyName(rt.RouteTable, name), rt.RouteTable.Namespace)
+			if err != nil {
+				if !k8serrors.IsNotFound(err) {
+					combinedError = errors.Join(combinedError, fmt.Errorf("failed to get MirrorPolicy: %s", err))
+				}
+				continue
+			}
+			// a policy of the same name the plugin didn't create is left alone
+			if mp.Labels[MirrorRouteLabel] != name {
+				continue
+			}
+			if dryRun {
+				r.LogCtx.Infof("dry run: not deleting mirror policy %s.%s", mp.Namespace, mp.Name)
+				continue
+			}
+			if err := r.Client.MirrorPolicies().DeleteMirrorPolicy(ctx, mp); err != nil && !k8serrors.IsNotFound(err) {
+				combinedError = errors.Join(combinedError, fmt.Errorf("failed to delete MirrorPolicy: %s", err))
+				continue
+			}
+			r.LogCtx.Debugf("deleted mirror policy %s.%s", mp.Namespace, mp.Name)
+		}
+	}
+	return combinedError
+}
+
+// buildGlooMirrorMatches converts the SetMirrorRoute matches; each match becomes one matcher
+func buildGlooMirrorMatches(setMirrorRoute *v1alpha1.SetMirrorRoute) ([]*solov2.HTTPRequestMatcher, error) {
+	var matchers []*solov2.HTTPRequestMatcher
+	for i, m := range setMirrorRoute.Match {
+		matcher := &solov2.HTTPRequestMatcher{
+			Name: fmt.Sprintf("%s-matcher-%d", setMirrorRoute.Name, i),
+		}
+		if m.Method != nil {
+			if m.Method.Exact == "" {
+				return nil, fmt.Errorf("mirror route %s: only exact method matches are supported", setMirrorRoute.Name)
+			}
+			matcher.Method = m.Method.Exact
+		}
+		if m.Path != nil {
+			matcher.Uri = buildGlooStringMatch(m.Path)
+		}
+
+		headerNames := make([]string, 0, len(m.Headers))
+		for name := range m.Headers {
+			headerNames = append(headerNames, name)
+		}
+		sort.Strings(headerNames)
+		for _, name := range headerNames {
+			value := m.Headers[name]
+			headerMatcher := &solov2.HeaderMatcher{
+				Name: name,
+			}
+			if value.Exact != "" {
+				headerMatcher.Value = value.Exact
+			} else if value.Regex != "" {
+				headerMatcher.Value = value.Regex
+				headerMatcher.Regex = true
+			} else if value.Prefix != "" {
+				headerMatcher.Value = "^" + regexp.QuoteMeta(value.Prefix)
+				headerMatcher.Regex = true
+			}
+			matcher.Headers = append(matcher.Headers, headerMatcher)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func buildGlooStringMatch(m *v1alpha1.StringMatch) *solov2.StringMatch {
+	if m.Exact != "" {
+		return &solov2.StringMatch{MatchType: &solov2.StringMatch_Exact{Exact: m.Exact}}
+	}
+	if m.Regex != "" {
+		return &solov2.StringMatch{MatchType: &solov2.StringMatch_Regex{Regex: m.Regex}}
+	}
+	if m.Prefix != "" {
+		return &solov2.StringMatch{MatchType: &solov2.StringMatch_Prefix{Prefix: m.Prefix}}
+	}
+	return nil
+}
+
+// mergeGlooMatchers ANDs the additional matchers into the route matchers. Gloo ORs the matchers of a route, so
+// every combination of route matcher and additional matcher becomes its own matcher.
+func mergeGlooMatchers(routeMatchers, additional []*solov2.HTTPRequestMatcher) []*solov2.HTTPRequestMatcher {
+	if len(routeMatchers) == 0 {
+		return additional
+	}
+	if len(additional) == 0 {
+		return routeMatchers
+	}
+
+	var merged []*solov2.HTTPRequestMatcher
+	for i, rm := range routeMatchers {
+		for _, am := range additional {
+			m := typedCloneProto(rm)
+			m.Name = fmt.Sprintf("%s-%d", am.GetName(), i)
+			if am.GetUri() != nil {
+				m.Uri = typedCloneProto(am.GetUri())
+			}
+			if am.GetMethod() != "" {
+				m.Method = am.GetMethod()
+			}
+			for _, h := range am.GetHeaders() {
+				m.Headers = append(m.Headers, typedCloneProto(h))
+			}
+			merged = append(merged, m)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+)
+
+// Plan runs the canary steps of the rollout up to and including the step with the given index against the RouteTables
+// of the client, as a dry run, and writes the matched routes and the changes of every matched RouteTable to out. A
+// negative step index runs all steps. The client has to hand out the RouteTables it holds rather than copies, like
+// gloo.NewInMemoryClientSet does, so that each step sees the changes of the steps before.
+func (r *RpcPlugin) Plan(rollout *v1alpha1.Rollout, step int, out io.Writer) error {
+	ctx := context.TODO()
+	glooPluginConfig, err := getPluginConfig(rollout)
+	if err != nil {
+		return err
+	}
+	if rollout.Spec.Strategy.Canary == nil {
+		return fmt.Errorf("rollout %s.%s has no canary strategy, only canary steps can be planned", rollout.Namespace, rollout.Name)
+	}
+	steps := rollout.Spec.Strategy.Canary.Steps
+	if step < 0 {
+		step = len(steps) - 1
+	}
+	if step >= len(steps) {
+		return fmt.Errorf("rollout %s.%s has %d steps, there is no step %d", rollout.Namespace, rollout.Name, len(steps), step)
+	}
+
+	// the RouteTables are never written, only changed in memory
+	planner := *r
+	planner.DryRun = true
+
+	matchedRts, err := planner.getRouteTables(ctx, rollout, glooPluginConfig)
+	if err != nil {
+		return err
+	}
+	if len(matchedRts) == 0 {
+		return noMatchingRoutesError(rollout, glooPluginConfig)
+	}
+	snapshots := make([]*networkv2.RouteTable, len(matchedRts))
+	fmt.Fprintln(out, "Matched routes:")
+	for i, rt := range matchedRts {
+		snapshots[i] = rt.RouteTable.DeepCopy()
+		fmt.Fprintf(out, "  RouteTable %s\n", routeTableKey(rt.RouteTable))
+		for _, route := range rt.weightedRoutes() {
+			fmt.Fprintf(out, "    route %s: stable %s, canary %s\n", route.name, describeDestination(route.destinations.StableOrActiveDestination), describeDestination(route.destinations.CanaryOrPreviewDestination))
+		}
+	}
+
+	fmt.Fprintln(out, "\nSteps:")
+	for i, canaryStep := range steps[:step+1] {
+		description, err := planner.planStep(rollout, canaryStep)
+		if err != nil {
+			return fmt.Errorf("step %d: %s", i, err)
+		}
+		fmt.Fprintf(out, "  %d: %s\n", i, description)
+	}
+
+	fmt.Fprintf(out, "\nRouteTables after step %d:\n", step)
+	for i, rt := range matchedRts {
+		fmt.Fprintf(out, "  RouteTable %s", routeTableKey(rt.RouteTable))
+		diff := routeTableDiff(snapshots[i], rt.RouteTable)
+		if diff == "" {
+			fmt.Fprintln(out, ": unchanged")
+			continue
+		}
+		fmt.Fprintf(out, ":\n%s", diff)
+	}
+	return nil
+}
+
+// planStep runs the traffic routing of the canary step and describes it
+func (r *RpcPlugin) planStep(rollout *v1alpha1.Rollout, step v1alpha1.CanaryStep) (string, error) {
+	switch {
+	case step.SetWeight != nil:
+		if rpcError := r.SetWeight(rollout, *step.SetWeight, []v1alpha1.WeightDestination{}); rpcError.HasError() {
+			return "", rpcError
+		}
+		return fmt.Sprintf("setWeight %d", *step.SetWeight), nil
+	case step.SetHeaderRoute != nil:
+		if rpcError := r.SetHeaderRoute(rollout, step.SetHeaderRoute); rpcError.HasError() {
+			return "", rpcError
+		}
+		return fmt.Sprintf("setHeaderRoute %s", step.SetHeaderRoute.Name), nil
+	case step.SetMirrorRoute != nil:
+		if rpcError := r.SetMirrorRoute(rollout, step.SetMirrorRoute); rpcError.HasError() {
+			return "", rpcError
+		}
+		return fmt.Sprintf("setMirrorRoute %s", step.SetMirrorRoute.Name), nil
+	case step.Pause != nil:
+		return "pause, no traffic change", nil
+	default:
+		return "no traffic change", nil
+	}
+}
+
+// describeDestination names the object a destination references
+func describeDestination(dest *solov2.DestinationReference) string {
+	if dest == nil {
+		return "none"
+	}
+	description := fmt.Sprintf("%s.%s", dest.GetRef().GetNamespace(), dest.GetRef().GetName())
+	if dest.GetRef().GetNamespace() == "" {
+		description = dest.GetRef().GetName()
+	}
+	if dest.GetRef().GetCluster() != "" {
+		description += "@" + dest.GetRef().GetCluster()
+	}
+	if dest.GetKind() != solov2.DestinationKind_SERVICE {
+		description += fmt.Sprintf(" (%s)", dest.GetKind())
+	}
+	if len(dest.GetSubset()) > 0 {
+		description += fmt.Sprintf(" subset %v", dest.GetSubset())
+	}
+	return description
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,14 +13,24 @@ import (
 
 	"github.com/PaesslerAG/gval"
 	"github.com/PaesslerAG/jsonpath"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/gloo"
 	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/mocks"
 	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	rolloutsPlugin "github.com/argoproj/argo-rollouts/rollout/trafficrouting/plugin/rpc"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/ghodss/yaml"
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
 	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	trafficv2 "github.com/solo-io/solo-apis/client-go/trafficcontrol.policy.gloo.solo.io/v2"
 	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 
 	goPlugin "github.com/hashicorp/go-plugin"
 )
@@ -31,11 +42,12 @@ var testHandshake = goPlugin.HandshakeConfig{
 }
 
 type TestCase struct {
-	Rollout        *v1alpha1.Rollout      `json:"rollout"`
-	RouteTable     *networkv2.RouteTable  `json:"routeTable"`
-	StepAssertions []StepAssertion        `json:"stepAssertions"`
-	assertionMap   map[int]*StepAssertion `json:"-"`
-	fileName       string                 `json:"-"`
+	Rollout             *v1alpha1.Rollout               `json:"rollout"`
+	RouteTable          *networkv2.RouteTable           `json:"routeTable"`
+	VirtualDestinations []*networkv2.VirtualDestination `json:"virtualDestinations"`
+	StepAssertions      []StepAssertion                 `json:"stepAssertions"`
+	assertionMap        map[int]*StepAssertion          `json:"-"`
+	fileName            string                          `json:"-"`
 }
 
 type StepAssertion struct {
@@ -65,6 +77,11 @@ func (tc *TestCase) Test(t *testing.T) error {
 	defer cancel()
 
 	mockClient := mocks.NewGlooMockClient([]*networkv2.RouteTable{tc.RouteTable})
+	for _, vd := range tc.VirtualDestinations {
+		if err := mockClient.VirtualDestinations().CreateVirtualDestination(ctx, vd); err != nil {
+			return err
+		}
+	}
 
 	rpcPluginImp := &RpcPlugin{
 		LogCtx: logCtx,
@@ -144,6 +161,10 @@ func (tc *TestCase) Test(t *testing.T) error {
 						stepAssertion(t, sa, tc.RouteTable)
 						// assert.NotEqual(t, len(tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations), 2)
 
+						verified, rpcError := pluginInstance.VerifyWeight(tc.Rollout, *step.SetWeight, []v1alpha1.WeightDestination{})
+						assert.Empty(t, rpcError.ErrorString)
+						assert.Equal(t, pluginTypes.Verified, verified)
+
 					}
 					if step.SetHeaderRoute != nil {
 						// test SetHeaderRoute
@@ -151,6 +172,11 @@ func (tc *TestCase) Test(t *testing.T) error {
 						assert.Empty(t, rpcError)
 						stepAssertion(t, sa, tc.RouteTable)
 					}
+					if step.SetMirrorRoute != nil {
+						rpcError := pluginInstance.SetMirrorRoute(tc.Rollout, step.SetMirrorRoute)
+						assert.Empty(t, rpcError.ErrorString)
+						stepAssertion(t, sa, tc.RouteTable)
+					}
 				}
 			}
 		}
@@ -279,6 +305,8 @@ func stepAssertion(t *testing.T, sa *StepAssertion, rt *networkv2.RouteTable) {
 		case string:
 			gvalParams["len"] = len(v)
 			gvalParams["value"] = v
+		case float64:
+			gvalParams["value"] = v
 		default:
 			t.Fatalf("test case parser doesn't understand type %T", v)
 		}
@@ -297,3 +325,1147 @@ func stepAssertion(t *testing.T, sa *StepAssertion, rt *networkv2.RouteTable) {
 		}
 	}
 }
+
+// loadTestCase reads the test case of a testfile
+func loadTestCase(t *testing.T, fileName string) *TestCase {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testfiles", fileName))
+	assert.Empty(t, err)
+	tc := &TestCase{
+		fileName: fileName,
+	}
+	assert.Empty(t, yaml.Unmarshal(data, tc))
+	return tc
+}
+
+// newTestPlugin returns a plugin working on the RouteTables through the mock client
+func newTestPlugin(routeTables ...*networkv2.RouteTable) (*RpcPlugin, *mocks.GlooMockClient) {
+	mockClient := mocks.NewGlooMockClient(routeTables).(*mocks.GlooMockClient)
+	return &RpcPlugin{
+		LogCtx: log.WithFields(log.Fields{"plugin": "trafficrouter"}),
+		Client: mockClient,
+	}, mockClient
+}
+
+func TestVerifyWeightDrift(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+
+	// another writer reverts the canary weight
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[0].Weight = 100
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[1].Weight = 0
+
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Contains(t, rpcError.ErrorString, "RouteTable gloo-mesh.default route demo: stable destination stable has weight 100, expected 90")
+	assert.Contains(t, rpcError.ErrorString, "canary destination canary has weight 0, expected 10")
+
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{{ServiceName: "experiment", Weight: 5}})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Contains(t, rpcError.ErrorString, "additional destination experiment not found")
+
+	// without a canary destination, the stable weight still has to leave room for the additional destinations
+	additionalDestinations := []v1alpha1.WeightDestination{{ServiceName: "experiment", Weight: 20}}
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, additionalDestinations)
+	assert.Empty(t, rpcError.ErrorString)
+	destinations := tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 3)
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations = []*solov2.DestinationReference{destinations[0], destinations[2]}
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 0, additionalDestinations)
+	assert.Equal(t, pluginTypes.Verified, verified)
+	assert.Empty(t, rpcError.ErrorString)
+
+	destinations[0].Weight = 100
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 0, additionalDestinations)
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Equal(t, "weights not verified: RouteTable gloo-mesh.default route demo: stable destination stable has weight 100, expected 80", rpcError.ErrorString)
+}
+
+func TestVerifyWeightTranslationStatus(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+
+	// the patch bumped the generation but Gloo hasn't caught up yet
+	tc.RouteTable.Generation = 2
+	tc.RouteTable.Status.Common.State.ObservedGeneration = 1
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Empty(t, rpcError.ErrorString)
+
+	tc.RouteTable.Status.Common.State.ObservedGeneration = 2
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.Verified, verified)
+	assert.Empty(t, rpcError.ErrorString)
+
+	tc.RouteTable.Status.Common.WorkspaceConditions = map[string]uint32{"ACCEPTED": 1, "INVALID": 1}
+	tc.RouteTable.Status.Common.State.Message = "destination canary not found"
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Contains(t, rpcError.ErrorString, "RouteTable gloo-mesh.default was rejected by Gloo with state INVALID in 1 workspace(s)")
+
+	tc.RouteTable.Status.Common.WorkspaceConditions = nil
+	tc.RouteTable.Status.Common.State.Approval = solov2.ApprovalState_FAILED
+	verified, rpcError = rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Contains(t, rpcError.ErrorString, "was rejected by Gloo with state FAILED: destination canary not found")
+}
+
+func TestUpdateHash(t *testing.T) {
+	tc := loadTestCase(t, "50-podTemplateHashSubsets.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.UpdateHash(tc.Rollout, "7d8e9f0a1", "5f6b7c8d9", []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	destinations := tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 1)
+	assert.Equal(t, "5f6b7c8d9", destinations[0].Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	destinations = tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 2)
+	assert.Equal(t, "5f6b7c8d9", destinations[0].Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+	assert.Equal(t, uint32(70), destinations[0].Weight)
+	assert.Equal(t, "7d8e9f0a1", destinations[1].Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+	assert.Equal(t, uint32(30), destinations[1].Weight)
+
+	// the canary is promoted; the next canary hash is not known yet so the destinations are matched by position
+	tc.Rollout.Status.StableRS = "7d8e9f0a1"
+	rpcError = rpcPluginImp.UpdateHash(tc.Rollout, "7d8e9f0a1", "7d8e9f0a1", []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, "7d8e9f0a1", destinations[0].Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+	assert.Equal(t, "7d8e9f0a1", destinations[1].Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+}
+
+func TestDerivedHeaderRouteCanary(t *testing.T) {
+	tc := loadTestCase(t, "50-podTemplateHashSubsets.yaml")
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+	headerRoute := &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	}
+
+	// the header route routes to the canary pods before any setWeight step created the canary destination
+	rpcError := rpcPluginImp.SetHeaderRoute(tc.Rollout, headerRoute)
+	assert.Empty(t, rpcError.ErrorString)
+	canary := tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[0]
+	assert.Equal(t, "stable", canary.GetRef().GetName())
+	assert.Equal(t, "7d8e9f0a1", canary.Subset[v1alpha1.DefaultRolloutUniqueLabelKey])
+
+	tc = loadTestCase(t, "10-basic-canary.yaml")
+	tc.Rollout.Spec.Strategy.Canary.CanaryService = ""
+	rpcPluginImp, _ = newTestPlugin(tc.RouteTable)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, headerRoute)
+	assert.Contains(t, rpcError.ErrorString, "rollout gloo-rollout-demo.demo has no canary or preview service to derive the canary destination of route demo from")
+}
+
+func TestAdditionalDestinations(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	matchedRt := &GlooMatchedRouteTable{RouteTable: tc.RouteTable, scope: newDestinationScope(tc.Rollout, &GlooPlatformAPITrafficRouting{})}
+	findDestination := matchedRt.findDestination
+
+	additionalDestinations := []v1alpha1.WeightDestination{{ServiceName: "experiment-a", Weight: 20}, {ServiceName: "experiment-b", Weight: 5}}
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, additionalDestinations)
+	assert.Empty(t, rpcError.ErrorString)
+	destinations := tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 4)
+	assert.Equal(t, uint32(65), findDestination(destinations, "stable").Weight)
+	assert.Equal(t, uint32(10), findDestination(destinations, "canary").Weight)
+	assert.Equal(t, uint32(20), findDestination(destinations, "experiment-a").Weight)
+	assert.Equal(t, uint32(5), findDestination(destinations, "experiment-b").Weight)
+	assert.Equal(t, uint32(8080), findDestination(destinations, "experiment-a").GetPort().GetNumber())
+	assert.Equal(t, `{"gloo-rollout-demo/demo":["experiment-a","experiment-b"]}`, tc.RouteTable.Annotations[AdditionalDestinationsAnnotation])
+
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, additionalDestinations)
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, pluginTypes.Verified, verified)
+
+	// experiment-b finished
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, additionalDestinations[:1])
+	assert.Empty(t, rpcError.ErrorString)
+	destinations = tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 3)
+	assert.Equal(t, uint32(70), findDestination(destinations, "stable").Weight)
+	assert.Nil(t, findDestination(destinations, "experiment-b"))
+	assert.Equal(t, `{"gloo-rollout-demo/demo":["experiment-a"]}`, tc.RouteTable.Annotations[AdditionalDestinationsAnnotation])
+
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	destinations = tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 2)
+	assert.Equal(t, uint32(90), findDestination(destinations, "stable").Weight)
+	assert.NotContains(t, tc.RouteTable.Annotations, AdditionalDestinationsAnnotation)
+
+	// another rollout sharing the route table keeps its additional destinations
+	tc.RouteTable.Annotations[AdditionalDestinationsAnnotation] = `{"gloo-rollout-demo/other":["experiment-c"]}`
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, additionalDestinations[:1])
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, `{"gloo-rollout-demo/demo":["experiment-a"],"gloo-rollout-demo/other":["experiment-c"]}`, tc.RouteTable.Annotations[AdditionalDestinationsAnnotation])
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Nil(t, findDestination(tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, "experiment-a"))
+	assert.Equal(t, `{"gloo-rollout-demo/other":["experiment-c"]}`, tc.RouteTable.Annotations[AdditionalDestinationsAnnotation])
+
+	// annotations without rollouts are the rollout's
+	tc.RouteTable.Annotations[AdditionalDestinationsAnnotation] = "experiment-a"
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations = append(tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, &solov2.DestinationReference{
+		RefKind: &solov2.DestinationReference_Ref{Ref: &solov2.ObjectReference{Name: "experiment-a", Namespace: "gloo-rollout-demo"}},
+	})
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 2)
+	assert.NotContains(t, tc.RouteTable.Annotations, AdditionalDestinationsAnnotation)
+
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 90, additionalDestinations)
+	assert.Contains(t, rpcError.ErrorString, "exceed 100")
+}
+
+func TestDestinationMatchers(t *testing.T) {
+	tc := loadTestCase(t, "90-destination-matchers.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	// a canary matcher without a ref doesn't tell which destination to create
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "stableDestinationMatcher": {"kind": "VIRTUAL_DESTINATION"}, "canaryDestinationMatcher": {"regexp": {"name": ".*-canary"}}}`)
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "has no destination matching the canaryDestinationMatcher")
+
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "stableDestinationMatcher": {"regexp": {"name": "demo-(stable"}}}`)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "invalid destination matcher regexp")
+
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "stableDestinationMatcher": {"kind": "Gateway"}}`)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "invalid destination matcher kind Gateway")
+}
+
+func TestCanaryVirtualDestination(t *testing.T) {
+	tc := loadTestCase(t, "100-virtualdestination-canary.yaml")
+
+	ctx := context.Background()
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	for _, vd := range tc.VirtualDestinations {
+		assert.Empty(t, mock.VirtualDestinations().CreateVirtualDestination(ctx, vd))
+	}
+
+	for _, weight := range []int32{10, 50} {
+		rpcError := rpcPluginImp.SetWeight(tc.Rollout, weight, []v1alpha1.WeightDestination{})
+		assert.Empty(t, rpcError.ErrorString)
+	}
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 2)
+
+	canaryVd, err := mock.VirtualDestinations().GetVirtualDestination(ctx, "stable-canary", "gloo-rollout-demo")
+	assert.Empty(t, err)
+	assert.Equal(t, []string{"stable-canary.demo.global"}, canaryVd.Spec.Hosts)
+	assert.Equal(t, "canary", canaryVd.Spec.Services[0].Name)
+	assert.Equal(t, "stable", canaryVd.Labels[CanaryVirtualDestinationLabel])
+	assert.Equal(t, "demo", canaryVd.OwnerReferences[0].Name)
+	assert.Equal(t, uint32(80), canaryVd.Spec.Ports[0].Number)
+
+	// the stable VirtualDestination doesn't select the stable service by name
+	stableVd, err := mock.VirtualDestinations().GetVirtualDestination(ctx, "stable", "gloo-rollout-demo")
+	assert.Empty(t, err)
+	stableVd.Spec.Services[0].Name = ""
+	patches := len(mock.RouteTablePatches())
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "has a service selector other than for service stable by name")
+	// the canary VirtualDestinations are synced before any RouteTable is written
+	assert.Len(t, mock.RouteTablePatches(), patches)
+	stableVd.Spec.Services[0].Name = "stable"
+
+	// the canary VirtualDestination goes with the canary destination once the rollout is done
+	tc.Rollout.Status.StableRS = "abc123"
+	tc.Rollout.Status.CurrentPodHash = "abc123"
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 1)
+	_, err = mock.VirtualDestinations().GetVirtualDestination(ctx, "stable-canary", "gloo-rollout-demo")
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = mock.VirtualDestinations().GetVirtualDestination(ctx, "stable", "gloo-rollout-demo")
+	assert.Empty(t, err)
+}
+
+func TestRemoveCanaryVirtualDestination(t *testing.T) {
+	tc := loadTestCase(t, "100-virtualdestination-canary.yaml")
+
+	ctx := context.Background()
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	for _, vd := range tc.VirtualDestinations {
+		assert.Empty(t, mock.VirtualDestinations().CreateVirtualDestination(ctx, vd))
+	}
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	_, err := mock.VirtualDestinations().GetVirtualDestination(ctx, "stable-canary", "gloo-rollout-demo")
+	assert.Empty(t, err)
+
+	// the canary VirtualDestination stays while a route still forwards to it
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	_, err = mock.VirtualDestinations().GetVirtualDestination(ctx, "stable-canary", "gloo-rollout-demo")
+	assert.Empty(t, err)
+
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations = tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[:1]
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	_, err = mock.VirtualDestinations().GetVirtualDestination(ctx, "stable-canary", "gloo-rollout-demo")
+	assert.True(t, k8serrors.IsNotFound(err))
+}
+
+func TestInvalidRouteSelectorExpression(t *testing.T) {
+	tc := loadTestCase(t, "110-routeSelector-labels.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "routeSelector": {"matchExpressions": [{"key": "tier", "operator": "Like", "values": ["internal"]}]}}`)
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "routeSelector: invalid label selector")
+}
+
+func TestRouteTableSelection(t *testing.T) {
+	newRouteTable := func(name, namespace string, hosts []string, virtualGateway string) *networkv2.RouteTable {
+		rt := &networkv2.RouteTable{}
+		rt.Name = name
+		rt.Namespace = namespace
+		rt.Labels = map[string]string{"app": "demo"}
+		rt.Spec.Hosts = hosts
+		rt.Spec.VirtualGateways = []*solov2.ObjectReference{{Name: virtualGateway}}
+		return rt
+	}
+	routeTables := []*networkv2.RouteTable{
+		newRouteTable("ingress", "gloo-mesh", []string{"api.example.com"}, "north-south"),
+		newRouteTable("team", "team-a", []string{"*.example.com"}, "east-west"),
+		newRouteTable("other", "team-b", []string{"api.example.com"}, "north-south"),
+		newRouteTable("unrelated", "gloo-mesh", []string{"www.example.org"}, "north-south"),
+	}
+	rollout := &v1alpha1.Rollout{}
+	rollout.Namespace = "gloo-rollout-demo"
+	rollout.Spec.Strategy.Canary = &v1alpha1.CanaryStrategy{StableService: "stable", CanaryService: "canary"}
+
+	rpcPluginImp, _ := newTestPlugin(routeTables...)
+	selectedNames := func(selector *SimpleObjectSelector) []string {
+		rts, err := rpcPluginImp.selectRouteTables(context.Background(), rollout, &GlooPlatformAPITrafficRouting{RouteTableSelector: selector})
+		assert.Empty(t, err)
+		var names []string
+		for _, rt := range rts {
+			names = append(names, rt.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"ingress", "unrelated", "team"}, selectedNames(&SimpleObjectSelector{Labels: map[string]string{"app": "demo"}, Namespace: "gloo-mesh", Namespaces: []string{"team-a"}}))
+	assert.Equal(t, []string{"ingress", "team", "other"}, selectedNames(&SimpleObjectSelector{AllNamespaces: true, Hosts: []string{"api.example.com"}}))
+	assert.Equal(t, []string{"ingress", "other"}, selectedNames(&SimpleObjectSelector{AllNamespaces: true, Hosts: []string{"API.example.com"}, VirtualGateways: []*solov2.ObjectReference{{Name: "north-south"}}}))
+	assert.Equal(t, []string{"other"}, selectedNames(&SimpleObjectSelector{AllNamespaces: true, VirtualGateways: []*solov2.ObjectReference{{Name: "north-south", Namespace: "team-b"}}}))
+	assert.Equal(t, []string{"team"}, selectedNames(&SimpleObjectSelector{Name: "team", AllNamespaces: true}))
+}
+
+func TestFollowDelegation(t *testing.T) {
+	newRouteTable := func(name, namespace string, route *networkv2.HTTPRoute) *networkv2.RouteTable {
+		rt := &networkv2.RouteTable{}
+		rt.Name = name
+		rt.Namespace = namespace
+		rt.Labels = map[string]string{"table": name}
+		rt.Spec.Http = []*networkv2.HTTPRoute{route}
+		rt.Status.Common = &solov2.Status{State: &solov2.State{Approval: solov2.ApprovalState_ACCEPTED}}
+		return rt
+	}
+	delegateTo := func(selector *solov2.ObjectSelector) *networkv2.HTTPRoute {
+		return &networkv2.HTTPRoute{
+			Name: "delegate",
+			ActionType: &networkv2.HTTPRoute_Delegate{
+				Delegate: &networkv2.DelegateAction{RouteTables: []*solov2.ObjectSelector{selector}},
+			},
+		}
+	}
+	stableRoute := &networkv2.HTTPRoute{
+		Name: "demo",
+		ActionType: &networkv2.HTTPRoute_ForwardTo{
+			ForwardTo: &networkv2.ForwardToAction{
+				Destinations: []*solov2.DestinationReference{{
+					RefKind: &solov2.DestinationReference_Ref{Ref: &solov2.ObjectReference{Name: "stable", Namespace: "gloo-rollout-demo"}},
+				}},
+			},
+		},
+	}
+	routeTables := []*networkv2.RouteTable{
+		newRouteTable("parent", "gloo-mesh", delegateTo(&solov2.ObjectSelector{Labels: map[string]string{"table": "child"}})),
+		newRouteTable("child", "team-a", delegateTo(&solov2.ObjectSelector{Name: "grandchild", Namespace: "team-a"})),
+		// delegation cycle back to the parent
+		newRouteTable("grandchild", "team-a", delegateTo(&solov2.ObjectSelector{Name: "parent", Namespace: "gloo-mesh"})),
+	}
+	routeTables[2].Spec.Http = append(routeTables[2].Spec.Http, stableRoute)
+
+	rollout := &v1alpha1.Rollout{}
+	rollout.Namespace = "gloo-rollout-demo"
+	rollout.Spec.Strategy.Canary = &v1alpha1.CanaryStrategy{
+		StableService: "stable",
+		CanaryService: "canary",
+		TrafficRouting: &v1alpha1.RolloutTrafficRouting{
+			Plugins: map[string]json.RawMessage{
+				PluginName: []byte(`{"routeTableSelector": {"name": "parent", "namespace": "gloo-mesh"}, "followDelegation": true}`),
+			},
+		},
+	}
+
+	rpcPluginImp, _ := newTestPlugin(routeTables...)
+	rpcError := rpcPluginImp.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	destinations := stableRoute.GetForwardTo().GetDestinations()
+	assert.Len(t, destinations, 2)
+	assert.Equal(t, uint32(70), destinations[0].Weight)
+	assert.Equal(t, "canary", destinations[1].GetRef().GetName())
+	assert.Equal(t, uint32(30), destinations[1].Weight)
+
+	verified, rpcError := rpcPluginImp.VerifyWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, pluginTypes.Verified, verified)
+}
+
+func TestMultipleTargets(t *testing.T) {
+	newRouteTable := func(name, namespace, routeLabel string) *networkv2.RouteTable {
+		rt := &networkv2.RouteTable{}
+		rt.Name = name
+		rt.Namespace = namespace
+		rt.Spec.Http = []*networkv2.HTTPRoute{{
+			Name:   "demo",
+			Labels: map[string]string{"route": routeLabel},
+			ActionType: &networkv2.HTTPRoute_ForwardTo{
+				ForwardTo: &networkv2.ForwardToAction{
+					Destinations: []*solov2.DestinationReference{{
+						RefKind: &solov2.DestinationReference_Ref{Ref: &solov2.ObjectReference{Name: "stable", Namespace: "gloo-rollout-demo"}},
+					}},
+				},
+			},
+		}}
+		rt.Status.Common = &solov2.Status{State: &solov2.State{Approval: solov2.ApprovalState_ACCEPTED}}
+		return rt
+	}
+	ingress := newRouteTable("ingress", "gloo-mesh", "north-south")
+	mesh := newRouteTable("mesh", "gloo-rollout-demo", "east-west")
+
+	rollout := &v1alpha1.Rollout{}
+	rollout.Namespace = "gloo-rollout-demo"
+	rollout.Spec.Strategy.Canary = &v1alpha1.CanaryStrategy{
+		StableService: "stable",
+		CanaryService: "canary",
+		TrafficRouting: &v1alpha1.RolloutTrafficRouting{
+			Plugins: map[string]json.RawMessage{
+				PluginName: []byte(`{"targets": [
+					{"routeTableSelector": {"name": "ingress", "namespace": "gloo-mesh"}, "routeSelector": {"labels": {"route": "north-south"}}},
+					{"routeTableSelector": {"name": "mesh"}, "routeSelector": {"labels": {"route": "east-west"}}, "weightPercentage": 50}
+				]}`),
+			},
+		},
+	}
+
+	rpcPluginImp, _ := newTestPlugin(ingress, mesh)
+	rpcError := rpcPluginImp.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, uint32(70), ingress.Spec.Http[0].GetForwardTo().Destinations[0].Weight)
+	assert.Equal(t, uint32(30), ingress.Spec.Http[0].GetForwardTo().Destinations[1].Weight)
+	assert.Equal(t, uint32(85), mesh.Spec.Http[0].GetForwardTo().Destinations[0].Weight)
+	assert.Equal(t, uint32(15), mesh.Spec.Http[0].GetForwardTo().Destinations[1].Weight)
+
+	verified, rpcError := rpcPluginImp.VerifyWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, pluginTypes.Verified, verified)
+
+	// both targets select the same table with different weight percentages
+	rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "mesh"}, "targets": [{"routeTableSelector": {"name": "mesh"}, "weightPercentage": 50}]}`)
+	rpcError = rpcPluginImp.SetWeight(rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "matched by targets with different weight percentages")
+}
+
+func TestRouteTableMatchSummary(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+
+	unrelated := &networkv2.RouteTable{}
+	unrelated.Name = "unrelated"
+	unrelated.Namespace = "gloo-mesh"
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable, unrelated)
+
+	// the first target's table can't be found, the second one's table has no matching routes, the third one matches
+	glooPluginConfig := &GlooPlatformAPITrafficRouting{
+		Targets: []*GlooPlatformAPITarget{
+			{RouteTableSelector: &SimpleObjectSelector{Name: "missing", Namespace: "gloo-mesh"}},
+			{RouteTableSelector: &SimpleObjectSelector{Name: "unrelated", Namespace: "gloo-mesh"}},
+			{RouteTableSelector: &SimpleObjectSelector{Name: "default", Namespace: "gloo-mesh"}},
+		},
+	}
+	matchedRts, err := rpcPluginImp.getRouteTables(context.Background(), tc.Rollout, glooPluginConfig)
+	assert.Empty(t, err)
+	assert.Len(t, matchedRts, 1)
+	assert.Equal(t, "default", matchedRts[0].RouteTable.Name)
+
+	// transactional updates and verification need every RouteTable
+	expected := `not all RouteTables are usable: target 0 errored: routetables.networking.gloo.solo.io "missing" not found; gloo-mesh.unrelated skipped`
+	_, err = rpcPluginImp.getAllRouteTables(context.Background(), tc.Rollout, glooPluginConfig)
+	assert.ErrorContains(t, err, expected)
+	glooPluginConfig.Transactional = true
+	_, err = rpcPluginImp.getRouteTables(context.Background(), tc.Rollout, glooPluginConfig)
+	assert.ErrorContains(t, err, expected)
+	glooPluginConfig.Transactional = false
+
+	// weights set in the usable RouteTables aren't verified while a RouteTable can't be read
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName], _ = json.Marshal(glooPluginConfig)
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Contains(t, rpcError.ErrorString, expected)
+
+	// nothing usable left once the stable service is missing
+	tc.Rollout.Spec.Strategy.Canary.StableService = ""
+	_, err = rpcPluginImp.getRouteTables(context.Background(), tc.Rollout, glooPluginConfig)
+	assert.ErrorContains(t, err, "no usable RouteTables found: target 0 errored: routetables.networking.gloo.solo.io \"missing\" not found; gloo-mesh.unrelated errored: rollout gloo-rollout-demo.demo has no stable or active service; gloo-mesh.default errored")
+}
+
+func TestNoMatchingRoutes(t *testing.T) {
+	tc := loadTestCase(t, "110-routeSelector-labels.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	tc.Rollout.Spec.Strategy.Canary.StableService = "other"
+	expected := `no routes of rollout gloo-rollout-demo.demo forwarding to service other, with canary service canary, found in the RouteTables selected by routeTableSelector (name demo, namespaces gloo-mesh) with routeSelector (labels app=demo,canary,tier notin (internal))`
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, expected, rpcError.ErrorString)
+
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{Name: "header"})
+	assert.Equal(t, expected, rpcError.ErrorString)
+
+	rpcError = rpcPluginImp.SetMirrorRoute(tc.Rollout, &v1alpha1.SetMirrorRoute{Name: "mirror", Match: []v1alpha1.RouteMatch{{Method: &v1alpha1.StringMatch{Exact: "GET"}}}})
+	assert.Equal(t, expected, rpcError.ErrorString)
+
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Equal(t, pluginTypes.NotVerified, verified)
+	assert.Equal(t, expected, rpcError.ErrorString)
+}
+
+func TestBlueGreenUnsupported(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	tc.Rollout.Spec.Strategy = v1alpha1.RolloutStrategy{
+		BlueGreen: &v1alpha1.BlueGreenStrategy{ActiveService: "stable", PreviewService: "canary"},
+	}
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 100, []v1alpha1.WeightDestination{})
+	assert.Equal(t, "rollout gloo-rollout-demo.demo has no canary strategy, the only strategy the solo-io/glooplatform plugin supports", rpcError.ErrorString)
+}
+
+func TestRemoveMirrorPolicies(t *testing.T) {
+	tc := loadTestCase(t, "40-setMirrorRoute.yaml")
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	ctx := context.Background()
+
+	// another rollout mirrors a route of the same name in its own route table
+	other := &trafficv2.MirrorPolicy{}
+	other.Name = "other-mirror-canary"
+	other.Namespace = "gloo-mesh"
+	other.Labels = map[string]string{MirrorRouteLabel: "mirror-canary", MirrorRouteTableLabel: "other"}
+	assert.Empty(t, mock.MirrorPolicies().CreateMirrorPolicy(ctx, other))
+
+	rpcError := rpcPluginImp.SetMirrorRoute(tc.Rollout, tc.Rollout.Spec.Strategy.Canary.Steps[0].SetMirrorRoute)
+	assert.Empty(t, rpcError.ErrorString)
+	mp, err := mock.MirrorPolicies().GetMirrorPolicy(ctx, "default-mirror-canary", "gloo-mesh")
+	assert.Empty(t, err)
+	assert.Equal(t, "default", mp.Labels[MirrorRouteTableLabel])
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 1)
+	_, err = mock.MirrorPolicies().GetMirrorPolicy(ctx, "default-mirror-canary", "gloo-mesh")
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = mock.MirrorPolicies().GetMirrorPolicy(ctx, "other-mirror-canary", "gloo-mesh")
+	assert.Empty(t, err)
+}
+
+func TestMirrorRouteForwardsToStable(t *testing.T) {
+	tc := loadTestCase(t, "40-setMirrorRoute.yaml")
+	api := typedCloneProto(tc.RouteTable.Spec.Http[0])
+	api.Name = "api"
+	api.Matchers[0].Uri = &solov2.StringMatch{MatchType: &solov2.StringMatch_Prefix{Prefix: "/api"}}
+	tc.RouteTable.Spec.Http = append(tc.RouteTable.Spec.Http, api)
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetMirrorRoute(tc.Rollout, tc.Rollout.Spec.Strategy.Canary.Steps[0].SetMirrorRoute)
+	assert.Empty(t, rpcError.ErrorString)
+
+	// each matched route gets a mirror route of its own, which forwards to the stable destination only
+	assert.Len(t, tc.RouteTable.Spec.Http, 4)
+	for i, name := range []string{"mirror-canary-demo", "mirror-canary-api"} {
+		mirrorRoute := tc.RouteTable.Spec.Http[i]
+		assert.Equal(t, name, mirrorRoute.Name)
+		assert.Len(t, mirrorRoute.GetForwardTo().Destinations, 1)
+		assert.Equal(t, "stable", mirrorRoute.GetForwardTo().Destinations[0].GetRef().GetName())
+		assert.Zero(t, mirrorRoute.GetForwardTo().Destinations[0].Weight)
+	}
+
+	// the mirror routes stay out of the traffic split
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 60, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 60, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, pluginTypes.Verified, verified)
+	for _, mirrorRoute := range tc.RouteTable.Spec.Http[:2] {
+		assert.Len(t, mirrorRoute.GetForwardTo().Destinations, 1)
+	}
+
+	// removing the mirror route removes the mirror routes of all source routes
+	rpcError = rpcPluginImp.SetMirrorRoute(tc.Rollout, &v1alpha1.SetMirrorRoute{Name: "mirror-canary"})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+	assert.Equal(t, "demo", tc.RouteTable.Spec.Http[0].Name)
+	assert.Equal(t, "api", tc.RouteTable.Spec.Http[1].Name)
+}
+
+func TestHeaderRouteMatchers(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.RouteTable.Spec.Http[0].Matchers = append(tc.RouteTable.Spec.Http[0].Matchers, &solov2.HTTPRequestMatcher{
+		Uri: &solov2.StringMatch{MatchType: &solov2.StringMatch_Prefix{Prefix: "/api"}},
+	})
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+
+	// every matcher of the header route requires the header, so requests without it keep going to the stable service
+	headerRoute := tc.RouteTable.Spec.Http[0]
+	assert.Equal(t, "header", headerRoute.Name)
+	assert.Len(t, headerRoute.Matchers, 2)
+	for i, prefix := range []string{"/demo", "/api"} {
+		assert.Equal(t, prefix, headerRoute.Matchers[i].GetUri().GetPrefix())
+		assert.Len(t, headerRoute.Matchers[i].Headers, 1)
+		assert.Equal(t, "x-canary", headerRoute.Matchers[i].Headers[0].Name)
+	}
+	assert.Equal(t, "canary", headerRoute.GetForwardTo().Destinations[0].GetRef().GetName())
+	stableRoute := tc.RouteTable.Spec.Http[1]
+	assert.Equal(t, "demo", stableRoute.Name)
+	assert.Len(t, stableRoute.GetForwardTo().Destinations, 1)
+	assert.Equal(t, "stable", stableRoute.GetForwardTo().Destinations[0].GetRef().GetName())
+
+	// a match without a header condition is rejected
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{}}},
+	})
+	assert.Equal(t, "header route header: the match of header x-canary has no exact, regex or prefix value", rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{Name: "header", Match: []v1alpha1.HeaderRoutingMatch{}})
+	assert.Equal(t, "header route header has no header match", rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+
+	// without a match the header route is removed
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{Name: "header"})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 1)
+	assert.Equal(t, "demo", tc.RouteTable.Spec.Http[0].Name)
+}
+
+func TestManagedRoutesNotMatched(t *testing.T) {
+	tc := loadTestCase(t, "40-setMirrorRoute.yaml")
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes = append(tc.Rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes, v1alpha1.MangedRoutes{Name: "header"})
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetMirrorRoute(tc.Rollout, tc.Rollout.Spec.Strategy.Canary.Steps[0].SetMirrorRoute)
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+
+	// only the authored route is cloned into the header route and weighted
+	routes := tc.RouteTable.Spec.Http
+	assert.Len(t, routes, 3)
+	assert.Equal(t, "header", routes[0].Name)
+	assert.NotContains(t, routes[0].Labels, MirrorRouteLabel)
+	assert.Equal(t, "mirror-canary", routes[1].Name)
+	assert.Len(t, routes[1].GetForwardTo().Destinations, 1)
+	assert.Equal(t, "demo", routes[2].Name)
+	assert.Len(t, routes[2].GetForwardTo().Destinations, 2)
+	assert.Equal(t, `{"demo":0}`, tc.RouteTable.Annotations[CanaryDestinationsAnnotation])
+}
+
+func TestRouteTablePatchConflicts(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.RouteTable.ResourceVersion = "42"
+
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	mock.ServeRouteTableCopies()
+
+	// another writer changed the route table twice meanwhile; the mutation is redone on the current version
+	mock.FailRouteTablePatches(2)
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 3)
+	for _, patch := range mock.RouteTablePatches() {
+		assert.Contains(t, patch, `"resourceVersion":"42"`)
+	}
+	destinations := tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations
+	assert.Len(t, destinations, 2)
+	assert.Equal(t, uint32(90), destinations[0].Weight)
+	assert.Equal(t, uint32(10), destinations[1].Weight)
+
+	// the route table keeps changing
+	mock.FailRouteTablePatches(10)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 20, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "failed to patch RouteTable gloo-mesh.default: still conflicting after 4 attempts")
+}
+
+func TestRouteTableApply(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.RouteTable.ResourceVersion = "42"
+	tc.RouteTable.Spec.Hosts = []string{"demo.example.com"}
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "writeMode": "apply"}`)
+
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 1)
+	// only the routes are applied, so the hosts stay owned by whoever set them
+	applied := mock.RouteTablePatches()[0]
+	assert.Contains(t, applied, `"resourceVersion":"42"`)
+	assert.Contains(t, applied, `"weight":10`)
+	assert.NotContains(t, applied, "demo.example.com")
+	assert.Equal(t, "43", tc.RouteTable.ResourceVersion)
+
+	// the next apply is based on the resourceVersion of the previous one
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 20, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 2)
+	assert.Contains(t, mock.RouteTablePatches()[1], `"resourceVersion":"43"`)
+
+	// routes owned by another field manager aren't taken over, and retrying doesn't help
+	mock.ConflictRouteTableApplies(1)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 30, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "routes are owned by another field manager, writeMode apply needs argo-rollouts-gloo-plugin to be the sole owner of the route lists")
+	assert.Len(t, mock.RouteTablePatches(), 3)
+
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "writeMode": "update"}`)
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 20, []v1alpha1.WeightDestination{})
+	assert.Equal(t, `plugin config of rollout gloo-rollout-demo.demo: writeMode "update" is not "patch", "apply" or "jsonPatch"`, rpcError.ErrorString)
+}
+
+func TestRouteTableJSONPatch(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "writeMode": "jsonPatch"}`)
+
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	mock.ServeRouteTableCopies()
+
+	// applies the last JSON patch to the route table as it was before
+	applyLastPatch := func(before []byte) ([]byte, error) {
+		patches := mock.RouteTablePatches()
+		patch, err := jsonpatch.DecodePatch([]byte(patches[len(patches)-1]))
+		assert.Empty(t, err)
+		return patch.Apply(before)
+	}
+
+	before, _ := json.Marshal(tc.RouteTable)
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 1)
+	assert.NotContains(t, mock.RouteTablePatches()[0], `"path":"/spec/http"`)
+	patched, err := applyLastPatch(before)
+	assert.Empty(t, err)
+	after, _ := json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(after), string(patched))
+
+	// only the weights change
+	before = after
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 50, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 2)
+	patched, err = applyLastPatch(before)
+	assert.Empty(t, err)
+	after, _ = json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(after), string(patched))
+
+	// the indexes are tested, so the patch doesn't apply to reordered routes
+	reordered := tc.RouteTable.DeepCopy()
+	reordered.Spec.Http = append([]*networkv2.HTTPRoute{{Name: "other"}}, reordered.Spec.Http...)
+	reorderedData, _ := json.Marshal(reordered)
+	_, err = applyLastPatch(reorderedData)
+	assert.NotEmpty(t, err)
+
+	// nothing changes, nothing is written
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 50, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 2)
+
+	// managed routes are added by index
+	before = after
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, mock.RouteTablePatches(), 3)
+	assert.Contains(t, mock.RouteTablePatches()[2], `{"op":"add","path":"/spec/http/0"`)
+	patched, err = applyLastPatch(before)
+	assert.Empty(t, err)
+	after, _ = json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(after), string(patched))
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+}
+
+func TestRouteTableJSONPatchInterleavedWriters(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	base := tc.RouteTable
+	base.ResourceVersion = "42"
+
+	// two writers patch the annotations of the route table as they both read it
+	patchAnnotation := func(value string) []byte {
+		desired := base.DeepCopy()
+		if desired.Annotations == nil {
+			desired.Annotations = map[string]string{}
+		}
+		desired.Annotations[CanaryDestinationsAnnotation] = value
+		patch, changed, err := gloo.BuildRouteTableJSONPatch(base, desired)
+		assert.Empty(t, err)
+		assert.True(t, changed)
+		return patch
+	}
+	// applies the patches one after another as the API server would, bumping the resourceVersion on every write
+	applyInOrder := func(patches ...[]byte) error {
+		data, _ := json.Marshal(base)
+		for i, patch := range patches {
+			decoded, err := jsonpatch.DecodePatch(patch)
+			assert.Empty(t, err)
+			if data, err = decoded.Apply(data); err != nil {
+				return err
+			}
+			written := &networkv2.RouteTable{}
+			assert.Empty(t, json.Unmarshal(data, written))
+			written.ResourceVersion = fmt.Sprintf("%d", 43+i)
+			data, _ = json.Marshal(written)
+		}
+		return nil
+	}
+
+	// adding an annotation can't test its absence, so the second writer fails on the resourceVersion
+	first, second := patchAnnotation(`{"demo":90}`), patchAnnotation(`{"demo":80}`)
+	assert.Contains(t, string(second), `{"op":"test","path":"/metadata/resourceVersion","value":"42"}`)
+	assert.Empty(t, applyInOrder(first))
+	assert.ErrorContains(t, applyInOrder(first, second), "test")
+
+	// changing or removing an annotation tests its previous value
+	base.Annotations = map[string]string{CanaryDestinationsAnnotation: `{"demo":100}`, "other": "kept"}
+	first, second = patchAnnotation(`{"demo":90}`), patchAnnotation(`{"demo":80}`)
+	assert.Contains(t, string(second), `{"op":"test","path":"/metadata/annotations/glooplatform.argoproj.io~1canary-destinations","value":"{\"demo\":100}"}`)
+	assert.NotContains(t, string(second), "/metadata/resourceVersion")
+	assert.Empty(t, applyInOrder(first))
+	assert.ErrorContains(t, applyInOrder(first, second), "test")
+}
+
+func TestRouteTableConflicts(t *testing.T) {
+	routeTables := schema.GroupResource{Group: networkv2.SchemeGroupVersion.Group, Resource: "routetables"}
+	changed := k8serrors.NewConflict(routeTables, "default", fmt.Errorf("the object has been modified"))
+	// the API server drops the message of the JSON patch library
+	testFailed := k8serrors.NewGenericServerResponse(http.StatusUnprocessableEntity, "patch", routeTables, "default", "testing value /spec/http/0/name failed: test failed", 0, false)
+	invalid := k8serrors.NewInvalid(networkv2.SchemeGroupVersion.WithKind("RouteTable").GroupKind(), "default", field.ErrorList{
+		field.Invalid(field.NewPath("spec", "http").Index(0).Child("forwardTo", "destinations").Index(0).Child("weight"), -1, "must be positive"),
+	})
+	fieldManagerConflict := k8serrors.NewApplyConflict([]metav1.StatusCause{{Type: metav1.CauseTypeFieldManagerConflict, Field: ".spec.http"}}, "Apply failed with 1 conflict")
+
+	for _, writeMode := range []string{WriteModePatch, WriteModeApply, WriteModeJSONPatch} {
+		assert.True(t, isRouteTableConflict(changed, writeMode), writeMode)
+		assert.Equal(t, writeMode == WriteModeJSONPatch, isRouteTableConflict(testFailed, writeMode), writeMode)
+		assert.False(t, isRouteTableConflict(invalid, writeMode), writeMode)
+		assert.False(t, isRouteTableConflict(fieldManagerConflict, writeMode), writeMode)
+		assert.False(t, isRouteTableConflict(fmt.Errorf("testing value /spec/http/0/name failed: test failed"), writeMode), writeMode)
+	}
+}
+
+func TestUnnamedRouteRecords(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.RouteTable.Spec.Http[0].Name = ""
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes = []v1alpha1.MangedRoutes{{Name: "header"}}
+	tc.Rollout.Spec.Strategy.Canary.Steps = append(tc.Rollout.Spec.Strategy.Canary.Steps, v1alpha1.CanaryStep{SetHeaderRoute: &v1alpha1.SetHeaderRoute{Name: "header"}})
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+	authored, _ := json.Marshal(tc.RouteTable)
+
+	tc.Rollout.Status.StableRS = "5f6b7c8d9"
+	tc.Rollout.Status.CurrentPodHash = "7d8e9f0a1"
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	records := tc.RouteTable.Annotations[CanaryDestinationsAnnotation]
+	assert.Regexp(t, `^\{"http#[0-9a-f]{8}":0\}$`, records)
+
+	// the record stays valid when a route is inserted before the unnamed one
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 2)
+	assert.Equal(t, records, tc.RouteTable.Annotations[CanaryDestinationsAnnotation])
+
+	// the rollout is promoted
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	tc.Rollout.Status.StableRS = "7d8e9f0a1"
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	restored, _ := json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(authored), string(restored))
+
+	// routes without names are told apart by their matchers
+	first := unnamedRouteName("http", []*solov2.HTTPRequestMatcher{{Uri: &solov2.StringMatch{MatchType: &solov2.StringMatch_Prefix{Prefix: "/a"}}}})
+	second := unnamedRouteName("http", []*solov2.HTTPRequestMatcher{{Uri: &solov2.StringMatch{MatchType: &solov2.StringMatch_Prefix{Prefix: "/b"}}}})
+	assert.NotEqual(t, first, second)
+}
+
+func TestTransactionalUpdates(t *testing.T) {
+	newRouteTable := func(name string) *networkv2.RouteTable {
+		rt := &networkv2.RouteTable{}
+		rt.Name = name
+		rt.Namespace = "gloo-mesh"
+		rt.Spec.Http = []*networkv2.HTTPRoute{{
+			Name: "demo",
+			ActionType: &networkv2.HTTPRoute_ForwardTo{
+				ForwardTo: &networkv2.ForwardToAction{
+					Destinations: []*solov2.DestinationReference{{
+						RefKind: &solov2.DestinationReference_Ref{Ref: &solov2.ObjectReference{Name: "stable", Namespace: "gloo-rollout-demo"}},
+						Weight:  100,
+					}},
+				},
+			},
+		}}
+		return rt
+	}
+	first := newRouteTable("first")
+	second := newRouteTable("second")
+	firstBefore, _ := json.Marshal(first)
+
+	rollout := &v1alpha1.Rollout{}
+	rollout.Namespace = "gloo-rollout-demo"
+	rollout.Name = "demo"
+	rollout.Spec.Strategy.Canary = &v1alpha1.CanaryStrategy{
+		StableService: "stable",
+		CanaryService: "canary",
+		TrafficRouting: &v1alpha1.RolloutTrafficRouting{
+			Plugins: map[string]json.RawMessage{
+				PluginName: []byte(`{"routeTableSelector": {"namespace": "gloo-mesh"}, "transactional": true}`),
+			},
+		},
+	}
+
+	rpcPluginImp, mock := newTestPlugin(first, second)
+	mock.ServeRouteTableCopies()
+	mock.RejectRouteTablePatches("second", 0)
+
+	// the first table was updated, the second can't be; the first is rolled back
+	rpcError := rpcPluginImp.SetWeight(rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Contains(t, rpcError.ErrorString, "failed to patch RouteTable gloo-mesh.second")
+	assert.Contains(t, rpcError.ErrorString, "rolled back the 1 RouteTable(s) updated before")
+	assert.Len(t, mock.RouteTablePatches(), 3)
+	firstAfter, _ := json.Marshal(first)
+	assert.JSONEq(t, string(firstBefore), string(firstAfter))
+
+	// the rollback only reverts what was written, and only as long as nobody changed it since
+	firstWritten, err := jsonpatch.MergePatch(firstBefore, []byte(mock.RouteTablePatches()[0]))
+	assert.Empty(t, err)
+	rollback, err := jsonpatch.DecodePatch([]byte(mock.RouteTablePatches()[2]))
+	assert.Empty(t, err)
+	changed := &networkv2.RouteTable{}
+	assert.Empty(t, json.Unmarshal(firstWritten, changed))
+	changed.Spec.Http = append(changed.Spec.Http, &networkv2.HTTPRoute{Name: "other"})
+	changedData, _ := json.Marshal(changed)
+	rolledBackData, err := rollback.Apply(changedData)
+	assert.Empty(t, err)
+	rolledBack := &networkv2.RouteTable{}
+	assert.Empty(t, json.Unmarshal(rolledBackData, rolledBack))
+	assert.Len(t, rolledBack.Spec.Http, 2)
+	assert.Equal(t, "other", rolledBack.Spec.Http[1].Name)
+	assert.Len(t, rolledBack.Spec.Http[0].GetForwardTo().Destinations, 1)
+	assert.Equal(t, uint32(100), rolledBack.Spec.Http[0].GetForwardTo().Destinations[0].Weight)
+	changed.Spec.Http[0].GetForwardTo().Destinations[1].Weight = 20
+	changedData, _ = json.Marshal(changed)
+	_, err = rollback.Apply(changedData)
+	assert.NotEmpty(t, err)
+
+	// the managed routes are removed transactionally too
+	mock.RejectRouteTablePatches("second", 0)
+	rollout.Spec.Strategy.Canary.Steps = []v1alpha1.CanaryStep{{SetHeaderRoute: &v1alpha1.SetHeaderRoute{Name: "header"}}}
+	rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes = []v1alpha1.MangedRoutes{{Name: "header"}}
+	first.Spec.Http = append([]*networkv2.HTTPRoute{{Name: "header"}}, first.Spec.Http...)
+	second.Spec.Http = append([]*networkv2.HTTPRoute{{Name: "header"}}, second.Spec.Http...)
+	rpcError = rpcPluginImp.RemoveManagedRoutes(rollout)
+	assert.Contains(t, rpcError.ErrorString, "rolled back the 1 RouteTable(s) updated before")
+	assert.Len(t, first.Spec.Http, 2)
+	first.Spec.Http = first.Spec.Http[1:]
+	second.Spec.Http = second.Spec.Http[1:]
+	rollout.Spec.Strategy.Canary.Steps = nil
+	rollout.Spec.Strategy.Canary.TrafficRouting.ManagedRoutes = nil
+
+	// the first table can't be rolled back either
+	mock.RejectRouteTablePatches("first", 1)
+	rpcError = rpcPluginImp.SetHeaderRoute(rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Contains(t, rpcError.ErrorString, "failed to patch RouteTable gloo-mesh.second")
+	assert.Contains(t, rpcError.ErrorString, "rolling back the RouteTables updated before failed: failed to roll back RouteTable gloo-mesh.first")
+	assert.Len(t, first.Spec.Http, 2)
+}
+
+func TestRemoveCanaryDestinations(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+
+	rpcPluginImp, _ := newTestPlugin(tc.RouteTable)
+	authored, _ := json.Marshal(tc.RouteTable)
+
+	tc.Rollout.Status.StableRS = "5f6b7c8d9"
+	tc.Rollout.Status.CurrentPodHash = "7d8e9f0a1"
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 2)
+	assert.Equal(t, `{"demo":0}`, tc.RouteTable.Annotations[CanaryDestinationsAnnotation])
+
+	// removing a header route mid-rollout leaves the canary destination alone, even while it gets no traffic
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{Name: "header"})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http, 1)
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 2)
+	assert.Equal(t, `{"demo":0}`, tc.RouteTable.Annotations[CanaryDestinationsAnnotation])
+
+	// the rollout is promoted
+	tc.Rollout.Status.StableRS = "7d8e9f0a1"
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	restored, _ := json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(authored), string(restored))
+
+	// the next rollout is aborted; the canary destination is kept as long as it gets traffic
+	tc.Rollout.Status.CurrentPodHash = "9a0b1c2d3"
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Len(t, tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations, 2)
+
+	tc.Rollout.Status.Abort = true
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 0, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	restored, _ = json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(authored), string(restored))
+
+	// a canary destination left at 0% is removed with the managed routes
+	tc.Rollout.Status.Abort = false
+	rpcError = rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[1].Weight = 0
+	tc.RouteTable.Spec.Http[0].GetForwardTo().Destinations[0].Weight = 100
+	rpcError = rpcPluginImp.RemoveManagedRoutes(tc.Rollout)
+	assert.Empty(t, rpcError.ErrorString)
+	restored, _ = json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(authored), string(restored))
+}
+
+func TestDryRun(t *testing.T) {
+	tc := loadTestCase(t, "10-basic-canary.yaml")
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}, "dryRun": true}`)
+	authored, _ := json.Marshal(tc.RouteTable)
+
+	logger, hook := logtest.NewNullLogger()
+	rpcPluginImp, mock := newTestPlugin(tc.RouteTable)
+	rpcPluginImp.LogCtx = logger.WithFields(log.Fields{"plugin": "trafficrouter"})
+	mock.ServeRouteTableCopies()
+
+	rpcError := rpcPluginImp.SetWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Empty(t, mock.RouteTablePatches())
+	unchanged, _ := json.Marshal(tc.RouteTable)
+	assert.JSONEq(t, string(authored), string(unchanged))
+	assert.Contains(t, hook.LastEntry().Message, "dry run: not writing the changes to route table gloo-mesh.default")
+	assert.Contains(t, hook.LastEntry().Message, "+++ desired")
+	assert.Contains(t, hook.LastEntry().Message, "+      - port:")
+
+	// the weights can't have been written, so they aren't verified
+	verified, rpcError := rpcPluginImp.VerifyWeight(tc.Rollout, 10, []v1alpha1.WeightDestination{})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Equal(t, pluginTypes.Verified, verified)
+	assert.Equal(t, "dry run: not verifying the weights of route table gloo-mesh.default, would check routes demo for weights stable 90, canary 10", hook.LastEntry().Message)
+
+	// all rollouts are dry run
+	rpcPluginImp.DryRun = true
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}}`)
+	rpcError = rpcPluginImp.SetHeaderRoute(tc.Rollout, &v1alpha1.SetHeaderRoute{
+		Name:  "header",
+		Match: []v1alpha1.HeaderRoutingMatch{{HeaderName: "x-canary", HeaderValue: &v1alpha1.StringMatch{Exact: "true"}}},
+	})
+	assert.Empty(t, rpcError.ErrorString)
+	assert.Empty(t, mock.RouteTablePatches())
+	assert.Contains(t, hook.LastEntry().Message, "+  - forwardTo:")
+}
+
+func TestPlan(t *testing.T) {
+	tc := loadTestCase(t, "20-setRouteHeader.yaml")
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "default", "namespace": "gloo-mesh"}}`)
+	authored, _ := json.Marshal(tc.RouteTable)
+
+	// plan runs against RouteTables held in memory, which are only found by their exact name and namespace
+	rpcPluginImp, _ := newTestPlugin()
+	rpcPluginImp.Client = gloo.NewInMemoryClientSet([]*networkv2.RouteTable{tc.RouteTable})
+
+	out := &strings.Builder{}
+	assert.Empty(t, rpcPluginImp.Plan(tc.Rollout, 1, out))
+	plan := out.String()
+	assert.Contains(t, plan, "RouteTable gloo-mesh.default\n    route demo: stable gloo-rollout-demo.stable, canary none\n")
+	assert.Contains(t, plan, "  0: setWeight 10\n  1: setHeaderRoute set-header-canary\n")
+	assert.NotContains(t, plan, "  2: ")
+	assert.Contains(t, plan, "+    name: set-header-canary\n")
+	assert.Contains(t, plan, "+        weight: 90\n")
+
+	// the RouteTables are only changed in memory
+	tc.RouteTable = &networkv2.RouteTable{}
+	assert.Empty(t, json.Unmarshal(authored, tc.RouteTable))
+	rpcPluginImp.Client = gloo.NewInMemoryClientSet([]*networkv2.RouteTable{tc.RouteTable})
+	out.Reset()
+	assert.Empty(t, rpcPluginImp.Plan(tc.Rollout, -1, out))
+	assert.Contains(t, out.String(), "  3: setWeight 100\n")
+	assert.Contains(t, out.String(), "RouteTables after step 3:")
+
+	err := rpcPluginImp.Plan(tc.Rollout, 4, out)
+	assert.EqualError(t, err, "rollout gloo-rollout-demo.demo has 4 steps, there is no step 4")
+
+	// a selector naming another RouteTable doesn't match, as it wouldn't in a cluster
+	tc.Rollout.Spec.Strategy.Canary.TrafficRouting.Plugins[PluginName] = []byte(`{"routeTableSelector": {"name": "demo", "namespace": "gloo-mesh"}}`)
+	out.Reset()
+	err = rpcPluginImp.Plan(tc.Rollout, -1, out)
+	assert.EqualError(t, err, `no usable RouteTables found: target 0 errored: routetables.networking.gloo.solo.io "demo" not found`)
+}
@@ -0,0 +1,382 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/gloo"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/ghodss/yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// routeTableAnnotations are the annotations the plugin keeps its state of a RouteTable in
+var routeTableAnnotations = []string{AdditionalDestinationsAnnotation, CanaryDestinationsAnnotation}
+
+// routeTableConflictBackoff bounds the attempts to write a RouteTable which keeps being changed by other writers
+var routeTableConflictBackoff = retry.DefaultBackoff
+
+// updateRouteTable applies the mutation to the matched RouteTable and writes the changes, guarded by the
+// resourceVersion or the indexes the changes are based on. When another writer changed the RouteTable meanwhile, it is
+// fetched and matched again and the mutation is redone on the current version, until the write succeeds or the
+// backoff runs out.
+func (r *RpcPlugin) updateRouteTable(ctx context.Context, rt *GlooMatchedRouteTable, mutate func(rt *GlooMatchedRouteTable) error) error {
+	attempts := 0
+	written := false
+	var mutateErr error
+	err := retry.OnError(routeTableConflictBackoff, func(err error) bool {
+		return mutateErr == nil && isRouteTableConflict(err, rt.writeMode())
+	}, func() error {
+		if attempts > 0 {
+			current, err := r.rematchRouteTable(ctx, rt)
+			if err != nil {
+				return err
+			}
+			*rt = *current
+		}
+		attempts++
+
+		// the original rt is preserved to use for patch generation
+		original := &networkv2.RouteTable{}
+		rt.RouteTable.DeepCopyInto(original)
+		if mutateErr = mutate(rt); mutateErr != nil {
+			return mutateErr
+		}
+
+		var err error
+		written, err = r.writeRouteTable(ctx, rt, original)
+		return err
+	})
+	switch {
+	case err == nil:
+	case mutateErr != nil:
+		return mutateErr
+	case isRouteTableConflict(err, rt.writeMode()):
+		return fmt.Errorf("failed to %s RouteTable %s.%s: still conflicting after %d attempts: %s", rt.writeMode(), rt.RouteTable.Namespace, rt.RouteTable.Name, attempts, err)
+	default:
+		return fmt.Errorf("failed to %s RouteTable %s.%s: %s", rt.writeMode(), rt.RouteTable.Namespace, rt.RouteTable.Name, err)
+	}
+
+	if r.IsTest {
+		return nil
+	}
+	if !written {
+		r.LogCtx.Debugf("route table %s.%s is unchanged", rt.RouteTable.Namespace, rt.RouteTable.Name)
+		return nil
+	}
+	r.LogCtx.Debugf("updated route table %s.%s with %s", rt.RouteTable.Namespace, rt.RouteTable.Name, rt.writeMode())
+	return nil
+}
+
+// writeRouteTable builds the patch from the original to the mutated route table and writes it, unless it is empty
+func (r *RpcPlugin) writeRouteTable(ctx context.Context, rt *GlooMatchedRouteTable, original *networkv2.RouteTable) (bool, error) {
+	var patch []byte
+	var changed bool
+	var err error
+	if rt.writeMode() == WriteModeJSONPatch {
+		patch, changed, err = gloo.BuildRouteTableJSONPatch(original, rt.RouteTable)
+	} else {
+		patch, changed, err = gloo.BuildRouteTablePatch(original, rt.RouteTable, gloo.WithAnnotations(), gloo.WithSpec(), gloo.WithResourceVersion(original.ResourceVersion))
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to build patch: %s", err)
+	}
+	if !changed {
+		return false, nil
+	}
+
+	if r.isDryRun(rt.config()) {
+		r.LogCtx.Infof("dry run: not writing the changes to route table %s.%s:\n%s", rt.RouteTable.Namespace, rt.RouteTable.Name, routeTableDiff(original, rt.RouteTable))
+		return false, nil
+	}
+	// don't actually patch the RT
+	if r.IsTest {
+		r.LogCtx.Debugf("test route table patch: %s", patch)
+		return true, nil
+	}
+	switch rt.writeMode() {
+	case WriteModeApply:
+		applied := routeTableApplyConfig(rt.RouteTable)
+		if err := r.Client.RouteTables().ApplyRouteTable(ctx, applied); err != nil {
+			if k8serrors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict) {
+				// the route lists are atomic, so the plugin can only apply them as their sole owner
+				return true, fmt.Errorf("routes are owned by another field manager, writeMode apply needs %s to be the sole owner of the route lists, use writeMode patch or hand them over: %s", gloo.FieldManager, err)
+			}
+			return true, err
+		}
+		// the next write is guarded by the resourceVersion the apply produced, not the one it was based on
+		rt.RouteTable.ResourceVersion = applied.ResourceVersion
+		return true, nil
+	case WriteModeJSONPatch:
+		return true, r.Client.RouteTables().PatchRouteTable(ctx, rt.RouteTable, client.RawPatch(types.JSONPatchType, patch))
+	default:
+		return true, r.Client.RouteTables().PatchRouteTable(ctx, rt.RouteTable, client.RawPatch(types.MergePatchType, patch))
+	}
+}
+
+// isRouteTableConflict reports whether the write failed because the RouteTable was changed by another writer. Fields
+// owned by another manager conflict with an apply however often it is retried.
+func isRouteTableConflict(err error, writeMode string) bool {
+	if k8serrors.HasStatusCause(err, metav1.CauseTypeFieldManagerConflict) {
+		return false
+	}
+	return k8serrors.IsConflict(err) || (writeMode == WriteModeJSONPatch && isJSONPatchNotApplied(err))
+}
+
+// isJSONPatchNotApplied reports whether the API server couldn't apply a JSON patch to the RouteTable, as when one of its
+// test operations fails. The API server rejects such a patch as invalid without causes and with a generic message,
+// while a patched RouteTable failing validation is rejected with the invalid fields as causes.
+func isJSONPatchNotApplied(err error) bool {
+	var apiStatus k8serrors.APIStatus
+	if !errors.As(err, &apiStatus) || apiStatus.Status().Reason != metav1.StatusReasonInvalid {
+		return false
+	}
+	status := apiStatus.Status()
+	if status.Details != nil && len(status.Details.Causes) > 0 {
+		return false
+	}
+	return strings.Contains(status.Message, "the server rejected our request due to an error in our request") ||
+		strings.Contains(status.Message, jsonpatch.ErrTestFailed.Error())
+}
+
+// updateRouteTables updates the matched route tables, all or none of them if they are to be updated transactionally,
+// or else as many of them as possible
+func (r *RpcPlugin) updateRouteTables(ctx context.Context, rts []*GlooMatchedRouteTable, mutate func(rt *GlooMatchedRouteTable) error) error {
+	if transactional(rts) {
+		return r.updateRouteTablesTransactionally(ctx, rts, mutate)
+	}
+	var combinedError error
+	for _, rt := range rts {
+		if err := r.updateRouteTable(ctx, rt, mutate); err != nil {
+			combinedError = errors.Join(combinedError, err)
+		}
+	}
+	return combinedError
+}
+
+// updateRouteTablesTransactionally updates the matched route tables one after another. When one fails, the changes
+// written to the route tables updated before are undone, so that all or none of them change.
+func (r *RpcPlugin) updateRouteTablesTransactionally(ctx context.Context, rts []*GlooMatchedRouteTable, mutate func(rt *GlooMatchedRouteTable) error) error {
+	// the versions the route tables had right before the mutation that was written, which may be a later one than the
+	// matched version after conflicts
+	befores := make([]*networkv2.RouteTable, len(rts))
+	for i, rt := range rts {
+		err := r.updateRouteTable(ctx, rt, func(rt *GlooMatchedRouteTable) error {
+			befores[i] = rt.RouteTable.DeepCopy()
+			return mutate(rt)
+		})
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			return err
+		}
+
+		var rollbackErrors []string
+		for j := i - 1; j >= 0; j-- {
+			if rollbackErr := r.undoRouteTableUpdate(ctx, rts[j], befores[j]); rollbackErr != nil {
+				rollbackErrors = append(rollbackErrors, rollbackErr.Error())
+			}
+		}
+		if len(rollbackErrors) > 0 {
+			return fmt.Errorf("%s; rolling back the RouteTables updated before failed: %s", err, strings.Join(rollbackErrors, "; "))
+		}
+		return fmt.Errorf("%s; rolled back the %d RouteTable(s) updated before", err, i)
+	}
+	return nil
+}
+
+// undoRouteTableUpdate reverts the changes the plugin wrote to the route table since it was the given version, and
+// nothing else. A JSON patch tests every element it reverts, so changes other writers made to other routes are kept,
+// while the rollback fails instead of reverting a route they changed too. An apply owns whole route lists, so it is
+// guarded by the resourceVersion the plugin wrote and fails if anything changed since.
+func (r *RpcPlugin) undoRouteTableUpdate(ctx context.Context, rt *GlooMatchedRouteTable, before *networkv2.RouteTable) error {
+	written := rt.RouteTable
+	patch, changed, err := gloo.BuildRouteTableJSONPatch(written, before)
+	if err != nil {
+		return fmt.Errorf("failed to build the patch rolling back RouteTable %s.%s: %s", written.Namespace, written.Name, err)
+	}
+	if !changed {
+		return nil
+	}
+	if r.isDryRun(rt.config()) {
+		r.LogCtx.Infof("dry run: not rolling back route table %s.%s:\n%s", written.Namespace, written.Name, routeTableDiff(written, before))
+		return nil
+	}
+
+	restored := written.DeepCopy()
+	if rt.writeMode() == WriteModeApply {
+		applied := routeTableApplyConfig(before)
+		applied.ResourceVersion = written.ResourceVersion
+		err = r.Client.RouteTables().ApplyRouteTable(ctx, applied)
+		restored.Spec.Http = before.Spec.Http
+		restored.Spec.Tcp = before.Spec.Tcp
+		restored.Spec.Tls = before.Spec.Tls
+		for _, annotation := range routeTableAnnotations {
+			if value, ok := before.Annotations[annotation]; ok {
+				if restored.Annotations == nil {
+					restored.Annotations = map[string]string{}
+				}
+				restored.Annotations[annotation] = value
+			} else {
+				delete(restored.Annotations, annotation)
+			}
+		}
+		restored.ResourceVersion = applied.ResourceVersion
+	} else {
+		if restored, err = applyRouteTableJSONPatch(written, patch); err != nil {
+			return fmt.Errorf("failed to roll back RouteTable %s.%s: %s", written.Namespace, written.Name, err)
+		}
+		err = r.Client.RouteTables().PatchRouteTable(ctx, restored, client.RawPatch(types.JSONPatchType, patch))
+	}
+	switch {
+	case err == nil:
+	case k8serrors.IsConflict(err) || isJSONPatchNotApplied(err):
+		return fmt.Errorf("not rolling back RouteTable %s.%s, it changed since it was updated: %s", written.Namespace, written.Name, err)
+	default:
+		return fmt.Errorf("failed to roll back RouteTable %s.%s: %s", written.Namespace, written.Name, err)
+	}
+	restored.DeepCopyInto(rt.RouteTable)
+	r.LogCtx.Debugf("rolled back route table %s.%s", written.Namespace, written.Name)
+	return nil
+}
+
+// applyRouteTableJSONPatch returns a copy of the route table with the JSON patch applied, as the API server would
+func applyRouteTableJSONPatch(rt *networkv2.RouteTable, patch []byte) (*networkv2.RouteTable, error) {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(rt)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decoded.Apply(data); err != nil {
+		return nil, err
+	}
+	patched := &networkv2.RouteTable{}
+	if err := json.Unmarshal(data, patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// transactional reports whether the route tables are to be updated all or none
+func transactional(rts []*GlooMatchedRouteTable) bool {
+	return slices.ContainsFunc(rts, func(rt *GlooMatchedRouteTable) bool {
+		return rt.config().Transactional
+	})
+}
+
+// config returns the plugin config of the first target the route table was matched by
+func (g *GlooMatchedRouteTable) config() *GlooPlatformAPITrafficRouting {
+	if len(g.targetConfigs) == 0 {
+		return &GlooPlatformAPITrafficRouting{}
+	}
+	return g.targetConfigs[0]
+}
+
+// writeMode returns how changes are written to the route table, as configured for the targets it was matched by
+func (g *GlooMatchedRouteTable) writeMode() string {
+	if g.config().WriteMode == "" {
+		return WriteModePatch
+	}
+	return g.config().WriteMode
+}
+
+// isDryRun reports whether changes are only logged, for all rollouts or as configured for this one
+func (r *RpcPlugin) isDryRun(glooPluginConfig *GlooPlatformAPITrafficRouting) bool {
+	return r.DryRun || (glooPluginConfig != nil && glooPluginConfig.DryRun)
+}
+
+// routeTableDiff returns a unified diff of the YAML of the live and the desired route table
+func routeTableDiff(live, desired *networkv2.RouteTable) string {
+	liveYaml, err := yaml.Marshal(live)
+	if err != nil {
+		return fmt.Sprintf("failed to marshal route table: %s", err)
+	}
+	desiredYaml, err := yaml.Marshal(desired)
+	if err != nil {
+		return fmt.Sprintf("failed to marshal route table: %s", err)
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveYaml)),
+		B:        difflib.SplitLines(string(desiredYaml)),
+		FromFile: "live",
+		ToFile:   "desired",
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("failed to diff route table: %s", err)
+	}
+	return diff
+}
+
+// routeTableApplyConfig returns the fields of the RouteTable the plugin manages: the routes and the plugin annotations.
+// The routes of each protocol are atomic lists, so they are owned as a whole, shared with the managers which applied
+// the same routes. The resourceVersion makes the apply fail with a conflict if the RouteTable changed since it was
+// read.
+func routeTableApplyConfig(rt *networkv2.RouteTable) *networkv2.RouteTable {
+	config := &networkv2.RouteTable{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            rt.Name,
+			Namespace:       rt.Namespace,
+			ResourceVersion: rt.ResourceVersion,
+		},
+		Spec: networkv2.RouteTableSpec{
+			Http: rt.Spec.Http,
+			Tcp:  rt.Spec.Tcp,
+			Tls:  rt.Spec.Tls,
+		},
+	}
+	for _, annotation := range routeTableAnnotations {
+		if value, ok := rt.Annotations[annotation]; ok {
+			if config.Annotations == nil {
+				config.Annotations = map[string]string{}
+			}
+			config.Annotations[annotation] = value
+		}
+	}
+	return config
+}
+
+// rematchRouteTable fetches the current version of the matched RouteTable and matches its routes again with the
+// configs of the targets it was matched by
+func (r *RpcPlugin) rematchRouteTable(ctx context.Context, rt *GlooMatchedRouteTable) (*GlooMatchedRouteTable, error) {
+	current, err := r.Client.RouteTables().GetRouteTable(ctx, rt.RouteTable.Name, rt.RouteTable.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get RouteTable %s.%s after a conflict: %s", rt.RouteTable.Namespace, rt.RouteTable.Name, err)
+	}
+
+	var rematched *GlooMatchedRouteTable
+	for _, targetConfig := range rt.targetConfigs {
+		matched := &GlooMatchedRouteTable{
+			RouteTable:       current,
+			weightPercentage: rt.weightPercentage,
+			rollout:          rt.rollout,
+			targetConfigs:    []*GlooPlatformAPITrafficRouting{targetConfig},
+		}
+		if err := matched.matchRoutes(r.LogCtx, rt.rollout, targetConfig); err != nil {
+			return nil, err
+		}
+		if rematched == nil {
+			rematched = matched
+			continue
+		}
+		rematched.merge(matched)
+	}
+	if rematched == nil {
+		return nil, fmt.Errorf("RouteTable %s.%s can't be matched again after a conflict", rt.RouteTable.Namespace, rt.RouteTable.Name)
+	}
+	return rematched, nil
+}
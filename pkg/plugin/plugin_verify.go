@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	pluginTypes "github.com/argoproj/argo-rollouts/utils/plugin/types"
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+)
+
+// verifyWeight checks that every matched route splits its traffic between the stable, canary and additional
+// destinations as desired. Drifted routes are described in the returned error.
+func (r *RpcPlugin) verifyWeight(glooMatchedRouteTables []*GlooMatchedRouteTable, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination) (pluginTypes.RpcVerified, pluginTypes.RpcError) {
+	var drifted, pending []string
+	for _, rt := range glooMatchedRouteTables {
+		canaryWeight := rt.scaleWeight(desiredWeight)
+		stableWeight := 100 - canaryWeight
+		for _, additional := range additionalDestinations {
+			stableWeight -= rt.scaleWeight(additional.Weight)
+		}
+
+		accepted, reason, err := checkTranslationStatus(rt.RouteTable)
+		if err != nil {
+			return pluginTypes.NotVerified, pluginTypes.RpcError{
+				ErrorString: err.Error(),
+			}
+		}
+		if !accepted {
+			pending = append(pending, reason)
+		}
+
+		for _, route := range rt.weightedRoutes() {
+			routeName := fmt.Sprintf("RouteTable %s.%s route %s", rt.RouteTable.Namespace, rt.RouteTable.Name, route.name)
+
+			stable := route.destinations.StableOrActiveDestination
+			canary := route.destinations.CanaryOrPreviewDestination
+			// a stable destination on its own gets all traffic, whatever its weight is
+			if len(*route.forwardTo) > 1 && stable.GetWeight() != uint32(stableWeight) {
+				drifted = append(drifted, fmt.Sprintf("%s: stable destination %s has weight %d, expected %d", routeName, stable.GetRef().GetName(), stable.GetWeight(), stableWeight))
+			}
+			if canary == nil {
+				if canaryWeight != 0 {
+					drifted = append(drifted, fmt.Sprintf("%s: canary destination not found, expected weight %d", routeName, canaryWeight))
+				}
+			} else if canary.GetWeight() != uint32(canaryWeight) {
+				drifted = append(drifted, fmt.Sprintf("%s: canary destination %s has weight %d, expected %d", routeName, canary.GetRef().GetName(), canary.GetWeight(), canaryWeight))
+			}
+
+			for _, additional := range additionalDestinations {
+				dest := rt.findDestination(*route.forwardTo, additional.ServiceName)
+				additionalWeight := rt.scaleWeight(additional.Weight)
+				if dest == nil {
+					drifted = append(drifted, fmt.Sprintf("%s: additional destination %s not found, expected weight %d", routeName, additional.ServiceName, additionalWeight))
+					continue
+				}
+				if dest.GetWeight() != uint32(additionalWeight) {
+					drifted = append(drifted, fmt.Sprintf("%s: additional destination %s has weight %d, expected %d", routeName, additional.ServiceName, dest.GetWeight(), additionalWeight))
+				}
+			}
+		}
+	}
+
+	if len(drifted) > 0 {
+		r.LogCtx.Infof("weights not verified: %s", strings.Join(drifted, "; "))
+		return pluginTypes.NotVerified, pluginTypes.RpcError{
+			ErrorString: fmt.Sprintf("weights not verified: %s", strings.Join(drifted, "; ")),
+		}
+	}
+
+	if len(pending) > 0 {
+		// not an error, Gloo just hasn't caught up yet
+		r.LogCtx.Infof("weights not verified: %s", strings.Join(pending, "; "))
+		return pluginTypes.NotVerified, pluginTypes.RpcError{}
+	}
+
+	return pluginTypes.Verified, pluginTypes.RpcError{}
+}
+
+// logDryRunVerification logs the weights verifyWeight would check in the matched routes
+func (r *RpcPlugin) logDryRunVerification(glooMatchedRouteTables []*GlooMatchedRouteTable, desiredWeight int32, additionalDestinations []v1alpha1.WeightDestination) {
+	for _, rt := range glooMatchedRouteTables {
+		canaryWeight := rt.scaleWeight(desiredWeight)
+		stableWeight := 100 - canaryWeight
+		expected := []string{}
+		for _, additional := range additionalDestinations {
+			stableWeight -= rt.scaleWeight(additional.Weight)
+			expected = append(expected, fmt.Sprintf("additional %s %d", additional.ServiceName, rt.scaleWeight(additional.Weight)))
+		}
+		expected = append([]string{fmt.Sprintf("stable %d", stableWeight), fmt.Sprintf("canary %d", canaryWeight)}, expected...)
+
+		var routeNames []string
+		for _, route := range rt.weightedRoutes() {
+			routeNames = append(routeNames, route.name)
+		}
+		r.LogCtx.Infof("dry run: not verifying the weights of route table %s.%s, would check routes %s for weights %s", rt.RouteTable.Namespace, rt.RouteTable.Name, strings.Join(routeNames, ", "), strings.Join(expected, ", "))
+	}
+}
+
+// checkTranslationStatus reports whether Gloo has accepted the current generation of the RouteTable in every workspace.
+// A RouteTable rejected by Gloo results in an error; a RouteTable not yet processed is not accepted, with the reason why.
+func checkTranslationStatus(rt *networkv2.RouteTable) (bool, string, error) {
+	rtName := fmt.Sprintf("RouteTable %s.%s", rt.Namespace, rt.Name)
+
+	common := rt.Status.GetCommon()
+	if common.GetState() == nil {
+		return false, fmt.Sprintf("%s has not been processed by Gloo yet", rtName), nil
+	}
+
+	state := common.GetState()
+	if state.GetObservedGeneration() < rt.Generation {
+		return false, fmt.Sprintf("%s generation %d has not been processed by Gloo yet, observed generation %d", rtName, rt.Generation, state.GetObservedGeneration()), nil
+	}
+
+	switch state.GetApproval() {
+	case solov2.ApprovalState_ACCEPTED, solov2.ApprovalState_WARNING:
+	case solov2.ApprovalState_PENDING:
+		return false, fmt.Sprintf("%s is pending translation by Gloo", rtName), nil
+	default:
+		return false, "", fmt.Errorf("%s was rejected by Gloo with state %s: %s", rtName, state.GetApproval(), state.GetMessage())
+	}
+
+	// the RouteTable may be exported to several workspaces, each of which translates it separately
+	for condition, count := range common.GetWorkspaceConditions() {
+		if count == 0 {
+			continue
+		}
+		switch {
+		case strings.EqualFold(condition, solov2.ApprovalState_PENDING.String()):
+			return false, fmt.Sprintf("%s is pending translation by Gloo in %d workspace(s)", rtName, count), nil
+		case strings.EqualFold(condition, solov2.ApprovalState_INVALID.String()),
+			strings.EqualFold(condition, solov2.ApprovalState_FAILED.String()),
+			strings.EqualFold(condition, solov2.ApprovalState_UNLICENSED.String()):
+			return false, "", fmt.Errorf("%s was rejected by Gloo with state %s in %d workspace(s): %s", rtName, strings.ToUpper(condition), count, state.GetMessage())
+		}
+	}
+
+	return true, "", nil
+}
@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	solov2 "github.com/solo-io/solo-apis/client-go/common.gloo.solo.io/v2"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CanaryVirtualDestinationLabel is set on canary VirtualDestinations with the name of the stable VirtualDestination they were cloned from
+	CanaryVirtualDestinationLabel = "glooplatform.argoproj.io/canary-virtualdestination"
+
+	canaryVirtualDestinationSuffix = "-canary"
+)
+
+func isVirtualDestination(dest *solov2.DestinationReference) bool {
+	return dest.GetKind() == solov2.DestinationKind_VIRTUAL_DESTINATION
+}
+
+// managesCanaryVirtualDestination reports whether the plugin clones the stable destination into a canary VirtualDestination
+func managesCanaryVirtualDestination(stable *solov2.DestinationReference, glooPluginConfig *GlooPlatformAPITrafficRouting) bool {
+	return glooPluginConfig.CanaryVirtualDestinations && !glooPluginConfig.PodTemplateHashSubsets && isVirtualDestination(stable)
+}
+
+// canaryVirtualDestinationRef returns the ref of the canary VirtualDestination cloned from the stable VirtualDestination
+func canaryVirtualDestinationRef(stable *solov2.DestinationReference) *solov2.ObjectReference {
+	ref := typedCloneProto(stable.GetRef())
+	ref.Name += canaryVirtualDestinationSuffix
+	return ref
+}
+
+// isCanaryVirtualDestinationOf reports whether the destination references the canary VirtualDestination of the stable one
+func isCanaryVirtualDestinationOf(dest, stable *solov2.DestinationReference, routeTableNamespace string) bool {
+	if !isVirtualDestination(dest) {
+		return false
+	}
+	canaryRef := canaryVirtualDestinationRef(stable)
+	scope := destinationScope{
+		namespace: canaryRef.GetNamespace(),
+		cluster:   canaryRef.GetCluster(),
+	}
+	if scope.namespace == "" {
+		scope.namespace = routeTableNamespace
+	}
+	return scope.refersTo(dest, canaryRef.GetName(), routeTableNamespace)
+}
+
+// ensureCanaryVirtualDestination syncs the canary VirtualDestination of the stable destination if the plugin manages it,
+// or only logs it in a dry run
+func (r *RpcPlugin) ensureCanaryVirtualDestination(ctx context.Context, rollout *v1alpha1.Rollout, rt *GlooMatchedRouteTable, stable *solov2.DestinationReference, glooPluginConfig *GlooPlatformAPITrafficRouting) error {
+	if !managesCanaryVirtualDestination(stable, glooPluginConfig) {
+		return nil
+	}
+	if r.isDryRun(glooPluginConfig) {
+		r.LogCtx.Infof("dry run: not syncing the canary VirtualDestination of %s", stable.GetRef().GetName())
+		return nil
+	}
+	return r.syncCanaryVirtualDestination(ctx, rollout, rt.RouteTable.Namespace, stable)
+}
+
+// syncCanaryVirtualDestination creates or updates the canary VirtualDestination of the stable VirtualDestination
+// destination, a clone of the stable VirtualDestination selecting the canary or preview service instead
+func (r *RpcPlugin) syncCanaryVirtualDestination(ctx context.Context, rollout *v1alpha1.Rollout, routeTableNamespace string, stable *solov2.DestinationReference) error {
+	namespace := stable.GetRef().GetNamespace()
+	if namespace == "" {
+		namespace = routeTableNamespace
+	}
+	stableVd, err := r.Client.VirtualDestinations().GetVirtualDestination(ctx, stable.GetRef().GetName(), namespace)
+	if err != nil {
+		return fmt.Errorf("failed to get VirtualDestination %s.%s: %s", namespace, stable.GetRef().GetName(), err)
+	}
+
+	name := canaryVirtualDestinationRef(stable).GetName()
+	desired := &networkv2.VirtualDestination{}
+	stableVd.Spec.DeepCopyInto(&desired.Spec)
+	if err := setCanaryVirtualDestinationSpec(&desired.Spec, stableVd, rollout); err != nil {
+		return err
+	}
+
+	existing, err := r.Client.VirtualDestinations().GetVirtualDestination(ctx, name, namespace)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get VirtualDestination %s.%s: %s", namespace, name, err)
+		}
+		desired.ObjectMeta = metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				CanaryVirtualDestinationLabel: stableVd.Name,
+			},
+		}
+		// owner references can't cross namespaces; a canary VirtualDestination elsewhere outlives its rollout
+		if namespace == rollout.Namespace {
+			desired.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(rollout, v1alpha1.SchemeGroupVersion.WithKind("Rollout"))}
+		}
+		if err := r.Client.VirtualDestinations().CreateVirtualDestination(ctx, desired); err != nil {
+			return fmt.Errorf("failed to create VirtualDestination: %s", err)
+		}
+		r.LogCtx.Debugf("created canary virtual destination %s.%s", namespace, name)
+		return nil
+	}
+
+	original := &networkv2.VirtualDestination{}
+	existing.DeepCopyInto(original)
+	desired.Spec.DeepCopyInto(&existing.Spec)
+	if err := r.Client.VirtualDestinations().PatchVirtualDestination(ctx, existing, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("failed to patch VirtualDestination: %s", err)
+	}
+	r.LogCtx.Debugf("patched canary virtual destination %s.%s", namespace, name)
+	return nil
+}
+
+// removeCanaryVirtualDestinations deletes the canary VirtualDestinations of the matched routes once no route of the
+// matched route tables forwards to them anymore, or only logs them in a dry run
+func (r *RpcPlugin) removeCanaryVirtualDestinations(ctx context.Context, glooPluginConfig *GlooPlatformAPITrafficRouting, matchedRts []*GlooMatchedRouteTable) error {
+	type canaryVirtualDestination struct {
+		name      string
+		namespace string
+		stable    string
+	}
+	candidates := map[string]canaryVirtualDestination{}
+	var inUse []string
+	for _, rt := range matchedRts {
+		for _, route := range rt.weightedRoutes() {
+			stable := route.destinations.StableOrActiveDestination
+			if !managesCanaryVirtualDestination(stable, glooPluginConfig) {
+				continue
+			}
+			namespace := stable.GetRef().GetNamespace()
+			if namespace == "" {
+				namespace = rt.RouteTable.Namespace
+			}
+			candidate := canaryVirtualDestination{
+				name:      canaryVirtualDestinationRef(stable).GetName(),
+				namespace: namespace,
+				stable:    stable.GetRef().GetName(),
+			}
+			key := fmt.Sprintf("%s/%s", candidate.namespace, candidate.name)
+			candidates[key] = candidate
+			// any route may still forward to the canary VirtualDestination, including the header and mirror routes
+			for _, matchedRt := range matchedRts {
+				if slices.ContainsFunc(routeTableDestinations(matchedRt.RouteTable), func(dest *solov2.DestinationReference) bool {
+					return isCanaryVirtualDestinationOf(dest, stable, matchedRt.RouteTable.Namespace)
+				}) {
+					inUse = append(inUse, key)
+				}
+			}
+		}
+	}
+
+	var combinedError error
+	for key, candidate := range candidates {
+		if slices.Contains(inUse, key) {
+			continue
+		}
+		vd, err := r.Client.VirtualDestinations().GetVirtualDestination(ctx, candidate.name, candidate.namespace)
+		if err != nil {
+			if !k8serrors.IsNotFound(err) {
+				combinedError = errors.Join(combinedError, fmt.Errorf("failed to get VirtualDestination %s.%s: %s", candidate.namespace, candidate.name, err))
+			}
+			continue
+		}
+		// a VirtualDestination of the same name the plugin didn't create is left alone
+		if vd.Labels[CanaryVirtualDestinationLabel] != candidate.stable {
+			continue
+		}
+		if r.isDryRun(glooPluginConfig) {
+			r.LogCtx.Infof("dry run: not deleting canary virtual destination %s.%s", vd.Namespace, vd.Name)
+			continue
+		}
+		if err := r.Client.VirtualDestinations().DeleteVirtualDestination(ctx, vd); err != nil && !k8serrors.IsNotFound(err) {
+			combinedError = errors.Join(combinedError, fmt.Errorf("failed to delete VirtualDestination %s.%s: %s", vd.Namespace, vd.Name, err))
+			continue
+		}
+		r.LogCtx.Debugf("deleted canary virtual destination %s.%s", vd.Namespace, vd.Name)
+	}
+	return combinedError
+}
+
+// routeTableDestinations returns the forwardTo destinations of all routes of the route table
+func routeTableDestinations(rt *networkv2.RouteTable) []*solov2.DestinationReference {
+	var destinations []*solov2.DestinationReference
+	for _, route := range rt.Spec.GetHttp() {
+		destinations = append(destinations, route.GetForwardTo().GetDestinations()...)
+	}
+	for _, route := range rt.Spec.GetTcp() {
+		destinations = append(destinations, route.GetForwardTo().GetDestinations()...)
+	}
+	for _, route := range rt.Spec.GetTls() {
+		destinations = append(destinations, route.GetForwardTo().GetDestinations()...)
+	}
+	return destinations
+}
+
+// setCanaryVirtualDestinationSpec turns the spec cloned from the stable VirtualDestination into the canary spec. The
+// hosts get a canary suffix, as hosts are unique across VirtualDestinations, and the service selectors select the canary
+// or preview service. Only selectors of the stable or active service by name can be turned into canary selectors.
+func setCanaryVirtualDestinationSpec(spec *networkv2.VirtualDestinationSpec, stableVd *networkv2.VirtualDestination, rollout *v1alpha1.Rollout) error {
+	stableService := getStableOrActiveService(rollout)
+	canaryService := getCanaryOrPreviewService(rollout)
+	if canaryService == "" {
+		return fmt.Errorf("rollout %s.%s has no canary or preview service to select in a canary VirtualDestination", rollout.Namespace, rollout.Name)
+	}
+
+	if len(spec.GetServices()) == 0 {
+		return fmt.Errorf("VirtualDestination %s.%s selects no services to derive a canary VirtualDestination from", stableVd.Namespace, stableVd.Name)
+	}
+	for _, selector := range spec.GetServices() {
+		if !strings.EqualFold(selector.GetName(), stableService) {
+			return fmt.Errorf("VirtualDestination %s.%s has a service selector other than for service %s by name", stableVd.Namespace, stableVd.Name, stableService)
+		}
+		selector.Name = canaryService
+	}
+	spec.ExternalServices = nil
+	spec.ExternalWorkloads = nil
+
+	for i, host := range spec.GetHosts() {
+		spec.Hosts[i] = canaryHost(host)
+	}
+	return nil
+}
+
+// canaryHost adds the canary suffix to the first label of the host
+func canaryHost(host string) string {
+	if i := strings.Index(host, "."); i >= 0 {
+		return host[:i] + canaryVirtualDestinationSuffix + host[i:]
+	}
+	return host + canaryVirtualDestinationSuffix
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/gloo"
+	"github.com/argoproj-labs/rollouts-plugin-trafficrouter-glooplatform/pkg/plugin"
+	"github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+	networkv2 "github.com/solo-io/solo-apis/client-go/networking.gloo.solo.io/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runPlan runs the plan subcommand: it loads a Rollout and RouteTables from YAML files and prints what the plugin
+// would do to the RouteTables up to a step of the Rollout, without a cluster
+func runPlan(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	rolloutFile := flags.String("rollout", "", "YAML file of the Rollout")
+	var routeTableFiles fileList
+	flags.Var(&routeTableFiles, "routetable", "YAML file of one or more RouteTables; may be repeated")
+	step := flags.Int("step", -1, "index of the last canary step to run; all steps by default")
+	verbose := flags.Bool("verbose", false, "log what the plugin does")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rolloutFile == "" || len(routeTableFiles) == 0 {
+		return fmt.Errorf("--rollout and --routetable are required")
+	}
+
+	// several documents would be merged into one Rollout
+	var rolloutDocuments [][]byte
+	if err := readYAMLDocuments(*rolloutFile, func(data []byte) error {
+		rolloutDocuments = append(rolloutDocuments, data)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(rolloutDocuments) != 1 {
+		return fmt.Errorf("--rollout takes a file with a single Rollout, %s has %d YAML documents", *rolloutFile, len(rolloutDocuments))
+	}
+	rollout := &v1alpha1.Rollout{}
+	if err := yaml.Unmarshal(rolloutDocuments[0], rollout); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", *rolloutFile, err)
+	}
+	if rollout.Namespace == "" {
+		rollout.Namespace = metav1.NamespaceDefault
+	}
+
+	var routeTables []*networkv2.RouteTable
+	for _, file := range routeTableFiles {
+		if err := readYAMLDocuments(file, func(data []byte) error {
+			rt := &networkv2.RouteTable{}
+			if err := yaml.Unmarshal(data, rt); err != nil {
+				return err
+			}
+			if rt.Namespace == "" {
+				rt.Namespace = metav1.NamespaceDefault
+			}
+			routeTables = append(routeTables, rt)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	logger := log.New()
+	logger.SetLevel(log.WarnLevel)
+	if *verbose {
+		logger.SetLevel(log.DebugLevel)
+	}
+	rpcPluginImp := &plugin.RpcPlugin{
+		LogCtx: logger.WithFields(log.Fields{"plugin": "trafficrouter"}),
+		Client: gloo.NewInMemoryClientSet(routeTables),
+	}
+	return rpcPluginImp.Plan(rollout, *step, out)
+}
+
+// readYAMLDocuments calls parse with every non-empty document of the YAML file
+func readYAMLDocuments(file string, parse func(data []byte) error) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for _, document := range yamlDocumentSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		if err := parse([]byte(document)); err != nil {
+			return fmt.Errorf("failed to parse %s: %s", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const planRollout = `apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: demo
+  namespace: gloo-rollout-demo
+spec:
+  strategy:
+    canary:
+      canaryService: canary
+      stableService: stable
+      trafficRouting:
+        plugins:
+          solo-io/glooplatform:
+            routeTableSelector:
+              name: demo
+              namespace: gloo-mesh
+      steps:
+      - setWeight: 10
+`
+
+const planRouteTable = `apiVersion: networking.gloo.solo.io/v2
+kind: RouteTable
+metadata:
+  name: demo
+  namespace: gloo-mesh
+spec:
+  http:
+  - name: demo
+    forwardTo:
+      destinations:
+      - ref:
+          name: stable
+          namespace: gloo-rollout-demo
+`
+
+func TestPlanRolloutDocuments(t *testing.T) {
+	dir := t.TempDir()
+	rollout := filepath.Join(dir, "rollout.yaml")
+	routeTable := filepath.Join(dir, "routetable.yaml")
+	assert.Empty(t, os.WriteFile(routeTable, []byte(planRouteTable), 0o600))
+
+	assert.Empty(t, os.WriteFile(rollout, []byte(planRollout), 0o600))
+	out := &bytes.Buffer{}
+	assert.Empty(t, runPlan([]string{"--rollout", rollout, "--routetable", routeTable}, out))
+	assert.Contains(t, out.String(), "route demo")
+
+	// a second Rollout in the file isn't merged into the first one
+	other := planRollout + "---\n" + planRollout
+	assert.Empty(t, os.WriteFile(rollout, []byte(other), 0o600))
+	err := runPlan([]string{"--rollout", rollout, "--routetable", routeTable}, &bytes.Buffer{})
+	assert.EqualError(t, err, "--rollout takes a file with a single Rollout, "+rollout+" has 2 YAML documents")
+}